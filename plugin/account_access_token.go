@@ -0,0 +1,114 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	rollbarAccountAccessTokenType = "rollbar_account_access_token"
+)
+
+func (b *RollbarBackend) rollbarAccountAccessToken() *framework.Secret {
+
+	return &framework.Secret{
+		Type: rollbarAccountAccessTokenType,
+		Fields: map[string]*framework.FieldSchema{
+			"account_access_token": {
+				Type:        framework.TypeString,
+				Description: "Rollbar Account Access Token",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+		},
+		Renew:  b.withSecretHistory(eventRenew, b.accountAccessTokenRenew),
+		Revoke: b.withSecretHistory(eventRevoke, b.accountAccessTokenRevoke),
+	}
+}
+
+func (b *RollbarBackend) accountAccessTokenRenew(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	roleRaw, ok := req.Secret.InternalData["role"]
+	if !ok {
+		return nil, fmt.Errorf("secret is missing role internal data")
+	}
+
+	// get the role entry
+	role := roleRaw.(string)
+	roleEntry, err := b.getRole(ctx, req.Storage, role)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving role: %w", err)
+	}
+
+	if roleEntry == nil {
+		return nil, errors.New("error retrieving role: role is nil")
+	}
+
+	if roleEntry.RoleType != roleTypeAccount {
+		return nil, fmt.Errorf("role %q no longer issues account access tokens", role)
+	}
+
+	resp := &logical.Response{Secret: req.Secret}
+	if roleEntry.TTL > 0 {
+		resp.Secret.TTL = roleEntry.TTL
+	}
+	if roleEntry.MaxTTL > 0 {
+		resp.Secret.MaxTTL = roleEntry.MaxTTL
+	}
+
+	if err := b.refreshCredential(ctx, req, resp.Secret.TTL); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (b *RollbarBackend) accountAccessTokenRevoke(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
+	}
+
+	aat, ok := req.Secret.InternalData["account_access_token"].(string)
+	if !ok || aat == "" {
+		return nil, fmt.Errorf("invalid value for account access token in secret internal data")
+	}
+
+	id, _ := req.Secret.InternalData["credential_id"].(string)
+	cred, err := getCredential(ctx, req.Storage, id)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving credential: %w", err)
+	}
+	// the token was already revoked along with its role
+	if cred == nil {
+		return nil, nil
+	}
+
+	if err := deleteAccountAccessToken(ctx, client, aat); err != nil {
+		if errors.Is(err, errRollbarUnavailable) {
+			return nil, fmt.Errorf("rollbar unavailable, deferring revocation of account access token: %w", err)
+		}
+		if !errors.Is(err, errRollbarNotFound) {
+			return nil, fmt.Errorf("error revoking account access token: %w", err)
+		}
+		b.Logger().Info("account access token already deleted in rollbar, treating revocation as successful", "credential_id", id)
+	}
+
+	if err := b.deleteCredential(ctx, req.Storage, id); err != nil {
+		return nil, fmt.Errorf("error removing credential record: %w", err)
+	}
+
+	return nil, nil
+}
+
+func createAccountAccessToken(ctx context.Context, c *rollbarClient, scopes []string, name string) (*string, error) {
+	return c.CreateAccountAccessToken(ctx, scopes, name)
+}
+
+func deleteAccountAccessToken(ctx context.Context, c *rollbarClient, aat string) error {
+	return c.deleteAccountAccessToken(ctx, aat)
+}
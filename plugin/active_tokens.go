@@ -0,0 +1,323 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// activeStoragePath indexes the tokens a role holds as
+	// active/<role>/<token name>/<credential ID>, so they can be counted
+	// with a single list of the role's prefix
+	activeStoragePath = "active/"
+
+	// walKindToken is the kind of the WAL entries that cover the creation
+	// of a rollbar token until it is recorded
+	walKindToken = "token"
+
+	// walRollbackMinAge leaves issuance in flight time to record or release
+	// its tokens before they are rolled back
+	walRollbackMinAge = 10 * time.Minute
+)
+
+// tokenReservation holds room under a role's max_active_tokens for a token
+// being created. It is written to the WAL before the token is created in
+// rollbar and deleted once the token is recorded, so a token created by a
+// request that did not complete is deleted by the WAL rollback.
+type tokenReservation struct {
+	Role         string `json:"role"`
+	Type         string `json:"type"`
+	ProjectID    int    `json:"project_id"`
+	TokenName    string `json:"token_name"`
+	CredentialID string `json:"credential_id"`
+	Pooled       bool   `json:"pooled"`
+
+	walID string
+}
+
+// activeTokenKey returns the storage key indexing a token held by a role.
+// Records from before token names were kept are indexed by their ID.
+func activeTokenKey(role, tokenName, credID string) string {
+	if tokenName == "" {
+		tokenName = credID
+	}
+	return activeStoragePath + role + "/" + tokenName + "/" + credID
+}
+
+// putActiveToken indexes a token held by a role
+func putActiveToken(ctx context.Context, s logical.Storage, role, tokenName, credID string) error {
+	return s.Put(ctx, &logical.StorageEntry{Key: activeTokenKey(role, tokenName, credID), Value: []byte{}})
+}
+
+// lockRoleIssuance serializes reserving room for new rollbar tokens of a
+// role so that max_active_tokens is checked and the reservations are
+// stored as one step. It returns the unlock function.
+func (b *RollbarBackend) lockRoleIssuance(role string) func() {
+	lock := locksutil.LockForKey(b.roleLocks, role)
+	lock.Lock()
+	return lock.Unlock
+}
+
+// countActiveTokens returns the number of rollbar tokens held by a role:
+// the distinct tokens in the credential inventory, where a reused token
+// counts once, the tokens being created and the tokens in the role's pool.
+// It is derived from storage so it survives restarts and drops as soon as
+// a token is revoked.
+func (b *RollbarBackend) countActiveTokens(ctx context.Context, s logical.Storage, role string) (int, error) {
+	names, err := s.List(ctx, activeStoragePath+role+"/")
+	if err != nil {
+		return 0, err
+	}
+
+	pooled, err := s.List(ctx, poolStoragePath+role+"/")
+	if err != nil {
+		return 0, err
+	}
+
+	return len(names) + len(pooled), nil
+}
+
+// checkActiveTokens returns an error response when creating count more
+// tokens would take a role past its max_active_tokens. The caller must hold
+// the role's issuance lock until room for the new tokens is reserved.
+func (b *RollbarBackend) checkActiveTokens(ctx context.Context, s logical.Storage, roleEntry *RollbarRoleEntry, count int) (*logical.Response, error) {
+	if roleEntry.MaxActiveTokens == 0 {
+		return nil, nil
+	}
+
+	active, err := b.countActiveTokens(ctx, s, roleEntry.Name)
+	if err != nil {
+		return nil, fmt.Errorf("error counting active tokens: %w", err)
+	}
+
+	if active+count > roleEntry.MaxActiveTokens {
+		return logical.ErrorResponse("role %q has %d of its max_active_tokens of %d active; revoke tokens before issuing more", roleEntry.Name, active, roleEntry.MaxActiveTokens), nil
+	}
+
+	return nil, nil
+}
+
+// reserveTokens reserves room for count new tokens of a role, named with
+// the given prefix followed by their credential ID. The role's issuance
+// lock is only held while the reservations are stored, not while the
+// tokens are created. Reservations for the pool are cut down to the room
+// left under max_active_tokens instead of failing.
+func (b *RollbarBackend) reserveTokens(ctx context.Context, s logical.Storage, roleEntry *RollbarRoleEntry, prefix string, count int, pooled bool) ([]*tokenReservation, *logical.Response, error) {
+	if roleEntry.MaxActiveTokens > 0 {
+		unlock := b.lockRoleIssuance(roleEntry.Name)
+		defer unlock()
+
+		if pooled {
+			active, err := b.countActiveTokens(ctx, s, roleEntry.Name)
+			if err != nil {
+				return nil, nil, fmt.Errorf("error counting active tokens: %w", err)
+			}
+			if room := roleEntry.MaxActiveTokens - active; count > room {
+				count = room
+			}
+		} else if resp, err := b.checkActiveTokens(ctx, s, roleEntry, count); err != nil || resp != nil {
+			return nil, resp, err
+		}
+	}
+
+	reservations := make([]*tokenReservation, 0, count)
+	for i := 0; i < count; i++ {
+		id, err := uuid.GenerateUUID()
+		if err != nil {
+			b.releaseReservations(ctx, s, reservations...)
+			return nil, nil, fmt.Errorf("error generating UUID for credential: %w", err)
+		}
+
+		r := &tokenReservation{
+			Role:         roleEntry.Name,
+			Type:         roleEntry.RoleType,
+			ProjectID:    roleEntry.ProjectID,
+			TokenName:    prefix + id,
+			CredentialID: id,
+			Pooled:       pooled,
+		}
+		r.walID, err = framework.PutWAL(ctx, s, walKindToken, r)
+		if err == nil {
+			err = putActiveToken(ctx, s, r.Role, r.TokenName, r.CredentialID)
+		}
+		if err != nil {
+			b.releaseReservations(ctx, s, append(reservations, r)...)
+			return nil, nil, fmt.Errorf("error reserving token: %w", err)
+		}
+		reservations = append(reservations, r)
+	}
+
+	return reservations, nil, nil
+}
+
+// commitReservations ends the reservations of tokens that were recorded.
+// The credential record of a token takes over its index entry, while a
+// pooled token is counted through the pool.
+func (b *RollbarBackend) commitReservations(ctx context.Context, s logical.Storage, reservations ...*tokenReservation) {
+	for _, r := range reservations {
+		if r == nil {
+			continue
+		}
+		if r.Pooled {
+			if err := s.Delete(ctx, activeTokenKey(r.Role, r.TokenName, r.CredentialID)); err != nil {
+				b.Logger().Warn("error removing reservation of pooled token", "name", r.TokenName, "error", err)
+			}
+		}
+		// a WAL entry left behind is rolled back as a no-op once the token
+		// is found recorded
+		if err := framework.DeleteWAL(ctx, s, r.walID); err != nil {
+			b.Logger().Warn("error removing WAL entry of recorded token", "name", r.TokenName, "error", err)
+		}
+	}
+}
+
+// releaseReservations gives back the room reserved for tokens that were
+// never created or have been deleted
+func (b *RollbarBackend) releaseReservations(ctx context.Context, s logical.Storage, reservations ...*tokenReservation) {
+	for _, r := range reservations {
+		if r == nil {
+			continue
+		}
+		if err := s.Delete(ctx, activeTokenKey(r.Role, r.TokenName, r.CredentialID)); err != nil {
+			b.Logger().Warn("error releasing token reservation", "name", r.TokenName, "error", err)
+		}
+		if r.walID == "" {
+			continue
+		}
+		if err := framework.DeleteWAL(ctx, s, r.walID); err != nil {
+			b.Logger().Warn("error removing WAL entry of released token", "name", r.TokenName, "error", err)
+		}
+	}
+}
+
+// releaseUnconfirmed gives back the room reserved for tokens rollbar did not
+// return although it reported success. Their WAL entries are kept, so the
+// WAL rollback deletes them by name if rollbar created them anyway.
+func (b *RollbarBackend) releaseUnconfirmed(ctx context.Context, s logical.Storage, reservations ...*tokenReservation) {
+	for _, r := range reservations {
+		if r == nil {
+			continue
+		}
+		if err := s.Delete(ctx, activeTokenKey(r.Role, r.TokenName, r.CredentialID)); err != nil {
+			b.Logger().Warn("error releasing token reservation", "name", r.TokenName, "error", err)
+		}
+	}
+}
+
+// releaseFailedCreate releases the reservations of tokens whose creation
+// failed, unless rollbar may have created them before the request failed.
+// Those are left to the WAL rollback, which deletes them if they exist.
+func (b *RollbarBackend) releaseFailedCreate(ctx context.Context, s logical.Storage, err error, reservations ...*tokenReservation) {
+	for _, rejected := range []error{errRollbarUnauthorized, errRollbarForbidden, errRollbarNotFound, errRollbarRateLimited, errRollbarValidation, errRollbarUnavailable} {
+		if errors.Is(err, rejected) {
+			b.releaseReservations(ctx, s, reservations...)
+			return
+		}
+	}
+}
+
+// walRollback is called by Vault for WAL entries left behind by requests
+// that did not complete
+func (b *RollbarBackend) walRollback(ctx context.Context, req *logical.Request, kind string, data interface{}) error {
+	if kind != walKindToken {
+		b.Logger().Warn("dropping WAL entry of unknown kind", "kind", kind)
+		return nil
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	r := new(tokenReservation)
+	if err := json.Unmarshal(raw, r); err != nil {
+		return fmt.Errorf("error reading WAL entry: %w", err)
+	}
+
+	return b.rollbackReservation(ctx, req.Storage, r)
+}
+
+// rollbackReservation deletes the rollbar token of a reservation that was
+// neither recorded nor released, and releases its room. A token found in
+// the inventory or the pool was recorded and is left alone.
+func (b *RollbarBackend) rollbackReservation(ctx context.Context, s logical.Storage, r *tokenReservation) error {
+	cred, err := getCredential(ctx, s, r.CredentialID)
+	if err != nil {
+		return err
+	}
+	if cred != nil {
+		return nil
+	}
+
+	key := activeTokenKey(r.Role, r.TokenName, r.CredentialID)
+
+	pooled, err := getPooledToken(ctx, s, r.Role, r.CredentialID)
+	if err != nil {
+		return err
+	}
+	if pooled != nil {
+		return s.Delete(ctx, key)
+	}
+
+	client, err := b.getClient(ctx, s)
+	if err != nil {
+		return fmt.Errorf("error getting client: %w", err)
+	}
+
+	if r.Type == roleTypeAccount {
+		tokens, err := client.ListAccountAccessTokens(ctx)
+		if err != nil {
+			return fmt.Errorf("error listing account access tokens: %w", err)
+		}
+		for _, token := range tokens {
+			if token.Name != r.TokenName {
+				continue
+			}
+			b.Logger().Warn("deleting account access token of interrupted issuance", "name", r.TokenName)
+			if err := deleteAccountAccessToken(ctx, client, token.AccessToken); err != nil && !errors.Is(err, errRollbarNotFound) {
+				return fmt.Errorf("error deleting account access token %q: %w", r.TokenName, err)
+			}
+		}
+	} else {
+		tokens, err := client.ListProjectAccessTokens(ctx, r.ProjectID)
+		if err != nil {
+			return fmt.Errorf("error listing project access tokens: %w", err)
+		}
+		for _, token := range tokens {
+			if token.Name != r.TokenName {
+				continue
+			}
+			b.Logger().Warn("deleting project access token of interrupted issuance", "name", r.TokenName)
+			if err := deleteProjectAccessToken(ctx, client, r.ProjectID, token.AccessToken); err != nil && !errors.Is(err, errRollbarNotFound) {
+				return fmt.Errorf("error deleting project access token %q: %w", r.TokenName, err)
+			}
+		}
+	}
+
+	return s.Delete(ctx, key)
+}
+
+// indexActiveTokens indexes the tokens of every credential in the
+// inventory, for storage written before the index existed
+func indexActiveTokens(ctx context.Context, s logical.Storage) error {
+	creds, err := listCredentials(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	for _, cred := range creds {
+		if err := putActiveToken(ctx, s, cred.Role, cred.TokenName, cred.ID); err != nil {
+			return fmt.Errorf("error indexing credential %q: %w", cred.ID, err)
+		}
+	}
+
+	return nil
+}
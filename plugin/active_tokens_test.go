@@ -0,0 +1,229 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestCountActiveTokens(t *testing.T) {
+	ctx := context.Background()
+	b, s := newTestBackend(t, nil)
+
+	creds := []*RollbarCredentialEntry{
+		{ID: "a", Role: "test", TokenName: "test-a"},
+		// a reused token counts once
+		{ID: "b", Role: "test", TokenName: "test-b"},
+		{ID: "c", Role: "test", TokenName: "test-b"},
+		{ID: "d", Role: "other", TokenName: "other-d"},
+	}
+	for _, cred := range creds {
+		if err := setCredential(ctx, s, cred); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entry, err := logical.StorageEntryJSON(poolStoragePath+"test/e", &pooledToken{ID: "e"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	assertActive := func(want int) {
+		t.Helper()
+		active, err := b.countActiveTokens(ctx, s, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if active != want {
+			t.Errorf("got %d active tokens, want %d", active, want)
+		}
+	}
+
+	assertActive(3)
+
+	if err := b.deleteCredential(ctx, s, "b"); err != nil {
+		t.Fatal(err)
+	}
+	assertActive(3)
+
+	if err := b.deleteCredential(ctx, s, "c"); err != nil {
+		t.Fatal(err)
+	}
+	assertActive(2)
+}
+
+func TestRefillPoolCreatesTokensWithoutIssuanceLock(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeProjectTokens()
+	_, config := newFakeRollbar(t, fake.ServeHTTP)
+	b, s := newTestBackend(t, config)
+
+	lock := locksutil.LockForKey(b.roleLocks, "test")
+	fake.onCreate = func() {
+		if !lock.TryLock() {
+			t.Error("token created while holding the role's issuance lock")
+			return
+		}
+		lock.Unlock()
+	}
+
+	client, err := b.getClient(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roleEntry := &RollbarRoleEntry{Name: "test", RoleType: roleTypeProject, ProjectID: 1, PoolSize: 3, MaxActiveTokens: 2}
+	if err := b.refillPool(ctx, s, client, roleEntry); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(fake.names()); got != 2 {
+		t.Errorf("pool refilled with %d tokens, want the 2 max_active_tokens allows", got)
+	}
+
+	active, err := b.countActiveTokens(ctx, s, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active != 2 {
+		t.Errorf("got %d active tokens, want 2", active)
+	}
+
+	wals, err := framework.ListWAL(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wals) != 0 {
+		t.Errorf("%d WAL entries left after refill", len(wals))
+	}
+}
+
+func TestWALRollbackDeletesUnrecordedToken(t *testing.T) {
+	cases := []struct {
+		name     string
+		recorded bool
+	}{
+		{name: "unrecorded"},
+		{name: "recorded", recorded: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			fake := newFakeProjectTokens()
+			_, config := newFakeRollbar(t, fake.ServeHTTP)
+			b, s := newTestBackend(t, config)
+
+			roleEntry := &RollbarRoleEntry{Name: "test", RoleType: roleTypeProject, ProjectID: 1, MaxActiveTokens: 1}
+			reservations, resp, err := b.reserveTokens(ctx, s, roleEntry, "test-", 1, false)
+			if err != nil || resp != nil {
+				t.Fatalf("reserving token: %v %v", resp, err)
+			}
+			r := reservations[0]
+
+			client, err := b.getClient(ctx, s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the request creating the token stops before recording it
+			if _, err := createProjectAccessToken(ctx, client, []string{"read"}, 1, r.TokenName); err != nil {
+				t.Fatal(err)
+			}
+			if tc.recorded {
+				cred := &RollbarCredentialEntry{ID: r.CredentialID, Type: roleTypeProject, Role: "test", ProjectID: 1, TokenName: r.TokenName}
+				if err := setCredential(ctx, s, cred); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// a second token cannot be issued while the first is reserved
+			if _, resp, err := b.reserveTokens(ctx, s, roleEntry, "test-", 1, false); err != nil || resp == nil {
+				t.Fatalf("expected max_active_tokens to be reached, got %v %v", resp, err)
+			}
+
+			entry, err := framework.GetWAL(ctx, s, r.walID)
+			if err != nil || entry == nil {
+				t.Fatalf("reading WAL entry: %v %v", entry, err)
+			}
+			if err := b.walRollback(ctx, &logical.Request{Storage: s}, entry.Kind, entry.Data); err != nil {
+				t.Fatal(err)
+			}
+
+			names := fake.names()
+			active, err := b.countActiveTokens(ctx, s, "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.recorded && (len(names) != 1 || active != 1) {
+				t.Errorf("recorded token rolled back: %d in rollbar, %d active", len(names), active)
+			}
+			if !tc.recorded && (len(names) != 0 || active != 0) {
+				t.Errorf("unrecorded token kept: %v in rollbar, %d active", names, active)
+			}
+		})
+	}
+}
+
+func TestEmptyTokenResponseKeepsWAL(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeProjectTokens()
+	// rollbar stores the token but answers without its value
+	_, config := newFakeRollbar(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			fake.ServeHTTP(httptest.NewRecorder(), r)
+			_, _ = w.Write([]byte(`{"err":0,"result":{}}`))
+			return
+		}
+		fake.ServeHTTP(w, r)
+	})
+	b, s := newTestBackend(t, config)
+
+	roleEntry := &RollbarRoleEntry{Name: "test", RoleType: roleTypeProject, ProjectID: 1, ProjectAccessTokenScopes: []string{"read"}, MaxActiveTokens: 1}
+	if err := setRole(ctx, s, roleEntry.Name, roleEntry); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "projectaccesstoken/test",
+		Storage:   s,
+	})
+	if err == nil {
+		t.Fatal("expected issuance to fail without a token")
+	}
+
+	// the room is given back right away
+	active, err := b.countActiveTokens(ctx, s, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active != 0 {
+		t.Errorf("%d tokens active after failed issuance, want 0", active)
+	}
+
+	wals, err := framework.ListWAL(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wals) != 1 {
+		t.Fatalf("%d WAL entries after failed issuance, want 1", len(wals))
+	}
+
+	entry, err := framework.GetWAL(ctx, s, wals[0])
+	if err != nil || entry == nil {
+		t.Fatalf("reading WAL entry: %v %v", entry, err)
+	}
+	if err := b.walRollback(ctx, &logical.Request{Storage: s}, entry.Kind, entry.Data); err != nil {
+		t.Fatal(err)
+	}
+	if names := fake.names(); len(names) != 0 {
+		t.Errorf("tokens left in rollbar after rollback: %v", names)
+	}
+}
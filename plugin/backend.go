@@ -4,8 +4,11 @@ import (
 	"context"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
@@ -17,6 +20,41 @@ type RollbarBackend struct {
 	*framework.Backend
 	lock   sync.RWMutex
 	client *rollbarClient
+
+	// projects caches rollbar project details by project ID
+	projects map[int]*rollbarProject
+
+	// lastReconcile is the start time of the last reconciliation run
+	lastReconcile time.Time
+
+	// stats tracks recent rollbar API calls across client rebuilds
+	stats *apiStats
+
+	// breaker guards rollbar API calls across client rebuilds
+	breaker *circuitBreaker
+
+	// poolLock serializes taking tokens out of and refilling the token pools
+	poolLock sync.Mutex
+
+	// reuseLocks serialize issuing and revoking reusable tokens per entity
+	reuseLocks []*locksutil.LockEntry
+
+	// roleLocks serialize reserving tokens per role for max_active_tokens
+	roleLocks []*locksutil.LockEntry
+
+	// quotaLocks serialize checking and recording per-entity quotas
+	quotaLocks []*locksutil.LockEntry
+
+	// credLocks serialize changes to credential inventory records
+	credLocks []*locksutil.LockEntry
+
+	// syncLock is held by role syncs, which apply as one step, and for
+	// reading by every other role write and delete
+	syncLock sync.RWMutex
+
+	// claimLocks serialize claiming and expiring tokens issued disabled
+	// per claim
+	claimLocks []*locksutil.LockEntry
 }
 
 // backendHelp defines the helptext for the rollbar backend
@@ -40,41 +78,101 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 // secrets it will store
 func newBackend() *RollbarBackend {
 
-	var b = RollbarBackend{}
+	var b = RollbarBackend{
+		stats:      &apiStats{},
+		breaker:    newCircuitBreaker(defaultBreakerFailureThreshold, defaultBreakerOpenDuration, defaultBreakerHalfOpenProbes),
+		reuseLocks: locksutil.CreateLocks(),
+		roleLocks:  locksutil.CreateLocks(),
+		quotaLocks: locksutil.CreateLocks(),
+		credLocks:  locksutil.CreateLocks(),
+		claimLocks: locksutil.CreateLocks(),
+	}
 	b.Backend = &framework.Backend{
 		Help: strings.TrimSpace(backendHelp),
 		PathsSpecial: &logical.Paths{
 			LocalStorage: []string{},
 			SealWrapStorage: []string{
-				"config",
-				"role/*",
+				configStoragePath,
+				pathRoleDef,
+				versionsStoragePath + versionsConfigKey + "/",
+				poolStoragePath,
+				reuseStoragePath,
+				claimStoragePath,
 			},
 		},
 		Paths: framework.PathAppend(
 			pathRole(&b),
+			pathCreds(&b),
+			pathVersions(&b),
+			pathQuotas(&b),
+			pathProjects(&b),
+			pathRolesSync(&b),
 			[]*framework.Path{
 				pathConfig(&b),
+				pathHistory(&b),
+				pathConfigPromote(&b),
+				// claim must be matched before the role name pattern
+				pathProjectAccessTokenClaim(&b),
 				pathProjectAccessToken(&b),
+				pathProjectAccessTokenBatch(&b),
+				pathAccountAccessToken(&b),
+				pathReconcile(&b),
+				pathBreaker(&b),
+				pathStatus(&b),
 				// API does't offer a route to rotate account access tokens
 				// pathConfigRotate(&b),
 			},
 		),
 		Secrets: []*framework.Secret{
 			b.rollbarProjectAccessToken(),
+			b.rollbarAccountAccessToken(),
+			b.rollbarProjectAccessTokenBatch(),
 		},
-		BackendType:    logical.TypeLogical,
-		Invalidate:     b.invalidate,
-		RunningVersion: Version,
+		PeriodicFunc:      b.periodicFunc,
+		InitializeFunc:    b.initialize,
+		BackendType:       logical.TypeLogical,
+		Invalidate:        b.invalidate,
+		RunningVersion:    Version,
+		WALRollback:       b.walRollback,
+		WALRollbackMinAge: walRollbackMinAge,
 	}
 
 	return &b
 }
 
+// periodicFunc is invoked by Vault's rollback manager and runs the
+// backend's background jobs
+func (b *RollbarBackend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	if !b.WriteSafeReplicationState() {
+		return nil
+	}
+
+	var merr *multierror.Error
+	if err := b.reconcileIfDue(ctx, req.Storage); err != nil {
+		merr = multierror.Append(merr, err)
+	}
+	if err := b.refillPools(ctx, req.Storage); err != nil {
+		merr = multierror.Append(merr, err)
+	}
+	if err := b.expireClaims(ctx, req.Storage); err != nil {
+		merr = multierror.Append(merr, err)
+	}
+	if err := b.pruneHistory(ctx, req.Storage); err != nil {
+		merr = multierror.Append(merr, err)
+	}
+	if err := b.pruneEntityUsage(ctx, req.Storage); err != nil {
+		merr = multierror.Append(merr, err)
+	}
+
+	return merr.ErrorOrNil()
+}
+
 // reset clears the rollbar client config for a new backend to be configured
 func (b *RollbarBackend) reset() {
 	b.lock.Lock()
 	defer b.lock.Unlock()
 	b.client = nil
+	b.projects = nil
 }
 
 // invalidate clears an existing rollbar client configuration within the backend
@@ -112,6 +210,34 @@ func (b *RollbarBackend) getClient(ctx context.Context, s logical.Storage) (*rol
 	if err != nil {
 		return nil, err
 	}
+	b.client.stats = b.stats
+	b.breaker.configure(breakerSettings(config))
+	b.client.breaker = b.breaker
 
 	return b.client, nil
 }
+
+// getProject returns the details of a rollbar project, looking them up
+// through the client the first time a project is seen
+func (b *RollbarBackend) getProject(ctx context.Context, client *rollbarClient, projectID int) (*rollbarProject, error) {
+	b.lock.RLock()
+	project, ok := b.projects[projectID]
+	b.lock.RUnlock()
+	if ok {
+		return project, nil
+	}
+
+	project, err := client.GetProject(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	b.lock.Lock()
+	if b.projects == nil {
+		b.projects = map[int]*rollbarProject{}
+	}
+	b.projects[projectID] = project
+	b.lock.Unlock()
+
+	return project, nil
+}
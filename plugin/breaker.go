@@ -0,0 +1,202 @@
+package plugin
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+
+	defaultBreakerFailureThreshold = 5
+	defaultBreakerOpenDuration     = 30 * time.Second
+	defaultBreakerHalfOpenProbes   = 1
+)
+
+// circuitBreaker stops calls to the rollbar API after repeated failures so
+// callers fail fast instead of waiting out the client timeout. Once open
+// for openDuration it lets halfOpenProbes calls through, closing again if
+// they all succeed.
+type circuitBreaker struct {
+	mu               sync.Mutex
+	failureThreshold int
+	openDuration     time.Duration
+	halfOpenProbes   int
+
+	state          string
+	failures       int
+	openedAt       time.Time
+	probesInFlight int
+	probeSuccesses int
+}
+
+// breakerState is a point in time view of a circuitBreaker
+type breakerState struct {
+	State            string    `json:"state"`
+	ConsecutiveFails int       `json:"consecutive_failures"`
+	FailureThreshold int       `json:"failure_threshold"`
+	OpenDuration     int64     `json:"open_duration"`
+	HalfOpenProbes   int       `json:"half_open_probes"`
+	OpenedAt         time.Time `json:"opened_at"`
+}
+
+func newCircuitBreaker(failureThreshold int, openDuration time.Duration, halfOpenProbes int) *circuitBreaker {
+	cb := &circuitBreaker{state: breakerClosed}
+	cb.configure(failureThreshold, openDuration, halfOpenProbes)
+	return cb
+}
+
+// configure applies new settings to the breaker. Its state is kept, so
+// rebuilding the client after a config write or restore does not close a
+// breaker that is open.
+func (cb *circuitBreaker) configure(failureThreshold int, openDuration time.Duration, halfOpenProbes int) {
+	if halfOpenProbes <= 0 {
+		halfOpenProbes = defaultBreakerHalfOpenProbes
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failureThreshold = failureThreshold
+	cb.openDuration = openDuration
+	cb.halfOpenProbes = halfOpenProbes
+}
+
+// allow reports whether a call may go through, returning
+// errRollbarUnavailable while the breaker is open
+func (cb *circuitBreaker) allow() error {
+	if cb == nil || cb.failureThreshold <= 0 {
+		return nil
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case breakerOpen:
+		if time.Since(cb.openedAt) < cb.openDuration {
+			return errRollbarUnavailable
+		}
+		cb.state = breakerHalfOpen
+		cb.probesInFlight = 0
+		cb.probeSuccesses = 0
+		fallthrough
+	case breakerHalfOpen:
+		if cb.probesInFlight >= cb.halfOpenProbes {
+			return errRollbarUnavailable
+		}
+		cb.probesInFlight++
+	}
+
+	return nil
+}
+
+// record feeds the outcome of a call allowed through back into the breaker.
+// A call canceled by its caller says nothing about rollbar's health: it
+// frees its probe slot but neither closes nor trips the breaker.
+func (cb *circuitBreaker) record(err error) {
+	if cb == nil || cb.failureThreshold <= 0 {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	canceled := errors.Is(err, errRollbarCanceled)
+	failed := isBreakerFailure(err)
+
+	switch cb.state {
+	case breakerHalfOpen:
+		cb.probesInFlight--
+		if canceled {
+			return
+		}
+		if failed {
+			cb.trip()
+			return
+		}
+		cb.probeSuccesses++
+		if cb.probeSuccesses >= cb.halfOpenProbes {
+			cb.state = breakerClosed
+			cb.failures = 0
+		}
+	case breakerClosed:
+		if canceled {
+			return
+		}
+		if !failed {
+			cb.failures = 0
+			return
+		}
+		cb.failures++
+		if cb.failures >= cb.failureThreshold {
+			cb.trip()
+		}
+	}
+}
+
+// trip opens the breaker, must be called with mu held
+func (cb *circuitBreaker) trip() {
+	cb.state = breakerOpen
+	cb.openedAt = time.Now().UTC()
+	cb.probesInFlight = 0
+	cb.probeSuccesses = 0
+}
+
+// snapshot returns the current state of the breaker
+func (cb *circuitBreaker) snapshot() breakerState {
+	if cb == nil {
+		return breakerState{State: breakerClosed}
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	state := cb.state
+	if state == breakerOpen && time.Since(cb.openedAt) >= cb.openDuration {
+		state = breakerHalfOpen
+	}
+
+	return breakerState{
+		State:            state,
+		ConsecutiveFails: cb.failures,
+		FailureThreshold: cb.failureThreshold,
+		OpenDuration:     int64(cb.openDuration.Seconds()),
+		HalfOpenProbes:   cb.halfOpenProbes,
+		OpenedAt:         cb.openedAt,
+	}
+}
+
+// isBreakerFailure reports whether an error means rollbar is unhealthy.
+// Rejections of a particular request, such as validation errors or
+// missing tokens, and calls canceled by the caller are not counted.
+func isBreakerFailure(err error) bool {
+	if err == nil || errors.Is(err, errRollbarCanceled) {
+		return false
+	}
+
+	var apiErr *rollbarAPIError
+	if errors.As(err, &apiErr) {
+		return errors.Is(err, errRollbarServer) || errors.Is(err, errRollbarRateLimited)
+	}
+
+	return true
+}
+
+// toResponseData returns response data for a breaker state
+func (s breakerState) toResponseData() map[string]interface{} {
+	data := map[string]interface{}{
+		"state":                s.State,
+		"consecutive_failures": s.ConsecutiveFails,
+		"failure_threshold":    s.FailureThreshold,
+		"open_duration":        s.OpenDuration,
+		"half_open_probes":     s.HalfOpenProbes,
+	}
+	if !s.OpenedAt.IsZero() {
+		data["opened_at"] = s.OpenedAt
+	}
+	return data
+}
@@ -0,0 +1,100 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestCircuitBreakerCanceledProbe(t *testing.T) {
+	cb := newCircuitBreaker(1, time.Millisecond, 1)
+
+	cb.record(errRollbarServer)
+	// wait out the open duration
+	time.Sleep(2 * time.Millisecond)
+
+	if err := cb.allow(); err != nil {
+		t.Fatalf("probe not allowed: %v", err)
+	}
+	cb.record(fmt.Errorf("%w: context canceled", errRollbarCanceled))
+
+	if cb.state != breakerHalfOpen {
+		t.Fatalf("canceled probe moved breaker to %s", cb.state)
+	}
+
+	// the canceled probe released its slot
+	if err := cb.allow(); err != nil {
+		t.Fatalf("probe not allowed after canceled probe: %v", err)
+	}
+	cb.record(errRollbarServer)
+
+	if cb.state != breakerOpen {
+		t.Fatalf("failed probe left breaker %s", cb.state)
+	}
+}
+
+func TestCircuitBreakerCanceledCallKeepsFailures(t *testing.T) {
+	cb := newCircuitBreaker(2, time.Minute, 1)
+
+	cb.record(errRollbarServer)
+	cb.record(fmt.Errorf("%w: context canceled", errRollbarCanceled))
+	cb.record(errRollbarServer)
+
+	if cb.state != breakerOpen {
+		t.Fatalf("breaker %s after consecutive failures around a canceled call", cb.state)
+	}
+}
+
+func TestCircuitBreakerSurvivesClientRebuild(t *testing.T) {
+	ctx := context.Background()
+	_, config := newFakeRollbar(t, respond(http.StatusInternalServerError, `{"err":1}`, 0))
+	config.BreakerFailureThreshold = 1
+	b, s := newTestBackend(t, config)
+
+	client, err := b.getClient(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ListProjectAccessTokens(ctx, 1); err == nil {
+		t.Fatal("expected the call to fail")
+	}
+
+	// writing or restoring the config rebuilds the client
+	b.reset()
+	client, err = b.getClient(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state := client.breaker.snapshot().State; state != breakerOpen {
+		t.Fatalf("breaker is %s after client rebuild, want open", state)
+	}
+	if _, err := client.ListProjectAccessTokens(ctx, 1); !errors.Is(err, errRollbarUnavailable) {
+		t.Errorf("call through open breaker returned %v", err)
+	}
+}
+
+func TestConfigRejectsZeroBreakerThreshold(t *testing.T) {
+	ctx := context.Background()
+	b, s := newTestBackend(t, nil)
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"account_access_token":      "account-token",
+			"breaker_failure_threshold": 0,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsError() {
+		t.Error("expected breaker_failure_threshold of 0 to be rejected")
+	}
+}
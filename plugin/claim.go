@@ -0,0 +1,217 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	claimStoragePath   = "claims/"
+	defaultClaimWindow = 15 * time.Minute
+)
+
+// pendingClaim is a project access token created disabled for a role with
+// activate_on_claim, waiting to be claimed with its one-time handle. Claims
+// are stored under a hash of the handle and are seal wrapped as they hold
+// the token value.
+type pendingClaim struct {
+	CredentialID string                    `json:"credential_id"`
+	Role         string                    `json:"role"`
+	ProjectID    int                       `json:"project_id"`
+	TokenName    string                    `json:"token_name"`
+	Token        rollbarProjectAccessToken `json:"token"`
+	ExpiresAt    time.Time                 `json:"expires_at"`
+}
+
+// claimKey returns the storage key of the claim with the given handle
+func claimKey(handle string) string {
+	sum := sha256.Sum256([]byte(handle))
+	return claimStoragePath + hex.EncodeToString(sum[:])
+}
+
+// claimWindow returns how long tokens issued by a role may wait to be
+// claimed
+func (r *RollbarRoleEntry) claimWindow() time.Duration {
+	if r.ClaimWindow > 0 {
+		return r.ClaimWindow
+	}
+	return defaultClaimWindow
+}
+
+// createClaim stores a pending claim and returns its handle and storage key
+func createClaim(ctx context.Context, s logical.Storage, claim *pendingClaim) (string, string, error) {
+	handle, err := uuid.GenerateUUID()
+	if err != nil {
+		return "", "", fmt.Errorf("error generating claim handle: %w", err)
+	}
+
+	key := claimKey(handle)
+	entry, err := logical.StorageEntryJSON(key, claim)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := s.Put(ctx, entry); err != nil {
+		return "", "", err
+	}
+
+	return handle, key, nil
+}
+
+// lockClaim serializes claiming, expiring and dropping a pending claim. It
+// returns the unlock function.
+func (b *RollbarBackend) lockClaim(key string) func() {
+	lock := locksutil.LockForKey(b.claimLocks, key)
+	lock.Lock()
+	return lock.Unlock
+}
+
+// claimToken removes the claim behind a claim handle so the handle cannot
+// be used again, then enables its token. The claim is put back when the
+// token could not be enabled. It returns an error response when the handle
+// is unknown, already used or past its claim window.
+func (b *RollbarBackend) claimToken(ctx context.Context, s logical.Storage, client *rollbarClient, handle string) (*pendingClaim, *logical.Response, error) {
+	key := claimKey(handle)
+
+	unlock := b.lockClaim(key)
+	defer unlock()
+
+	claim, err := getClaim(ctx, s, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if claim == nil {
+		return nil, logical.ErrorResponse("unknown or already used claim handle"), nil
+	}
+
+	if time.Now().After(claim.ExpiresAt) {
+		return nil, logical.ErrorResponse("claim window has passed, the token is being deleted"), nil
+	}
+
+	if err := s.Delete(ctx, key); err != nil {
+		return nil, nil, err
+	}
+
+	if err := client.SetProjectAccessTokenStatus(ctx, claim.ProjectID, claim.Token.AccessToken, tokenStatusEnabled); err != nil {
+		// the token is left disabled and deleted along with its lease if
+		// the claim cannot be put back
+		if entry, putErr := logical.StorageEntryJSON(key, claim); putErr != nil {
+			b.Logger().Warn("error restoring claim of token that could not be enabled", "name", claim.TokenName, "error", putErr)
+		} else if putErr := s.Put(ctx, entry); putErr != nil {
+			b.Logger().Warn("error restoring claim of token that could not be enabled", "name", claim.TokenName, "error", putErr)
+		}
+		return nil, nil, rollbarCodedError(err, "error enabling claimed project access token")
+	}
+
+	err = b.updateCredential(ctx, s, claim.CredentialID, func(cred *RollbarCredentialEntry) {
+		cred.PendingClaim = false
+	})
+	if err != nil {
+		b.Logger().Warn("error marking credential as claimed", "credential_id", claim.CredentialID, "error", err)
+	}
+
+	claim.Token.Status = tokenStatusEnabled
+
+	return claim, nil, nil
+}
+
+// expireClaims deletes the tokens that were not claimed within their claim
+// window, along with their claim and inventory record. Their leases are
+// then revoked as already revoked tokens.
+func (b *RollbarBackend) expireClaims(ctx context.Context, s logical.Storage) error {
+	keys, err := s.List(ctx, claimStoragePath)
+	if err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	client, err := b.getClient(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	var merr *multierror.Error
+	for _, k := range keys {
+		if err := b.expireClaim(ctx, s, client, claimStoragePath+k); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+
+	return merr.ErrorOrNil()
+}
+
+// expireClaim deletes the token of a claim past its claim window, along
+// with the claim and the inventory record. It holds the claim's lock so
+// the claim cannot be claimed meanwhile.
+func (b *RollbarBackend) expireClaim(ctx context.Context, s logical.Storage, client *rollbarClient, key string) error {
+	unlock := b.lockClaim(key)
+	defer unlock()
+
+	claim, err := getClaim(ctx, s, key)
+	if err != nil {
+		return err
+	}
+	if claim == nil || time.Now().Before(claim.ExpiresAt) {
+		return nil
+	}
+
+	err = deleteProjectAccessToken(ctx, client, claim.ProjectID, claim.Token.AccessToken)
+	if err != nil && !errors.Is(err, errRollbarNotFound) {
+		return fmt.Errorf("error deleting unclaimed token %q: %w", claim.TokenName, err)
+	}
+
+	cred, err := getCredential(ctx, s, claim.CredentialID)
+	if err != nil {
+		return err
+	}
+
+	if err := b.deleteCredential(ctx, s, claim.CredentialID); err != nil {
+		return err
+	}
+
+	if cred != nil {
+		e := credentialEvent(eventRevoke, cred)
+		e.Outcome = "unclaimed"
+		b.recordEvent(ctx, s, e)
+	}
+
+	if err := s.Delete(ctx, key); err != nil {
+		return err
+	}
+
+	b.Logger().Info("deleted unclaimed project access token", "role", claim.Role, "name", claim.TokenName)
+
+	return nil
+}
+
+// getClaim gets a pending claim from the Vault storage API
+func getClaim(ctx context.Context, s logical.Storage, key string) (*pendingClaim, error) {
+	entry, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	claim := new(pendingClaim)
+	if err := entry.DecodeJSON(claim); err != nil {
+		return nil, fmt.Errorf("error reading claim: %w", err)
+	}
+
+	return claim, nil
+}
@@ -0,0 +1,161 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// setClaimRole stores a project role issuing its tokens disabled until
+// they are claimed
+func setClaimRole(t *testing.T, s logical.Storage) {
+	t.Helper()
+
+	role := &RollbarRoleEntry{
+		Name:                     "test",
+		RoleType:                 roleTypeProject,
+		ProjectID:                1,
+		ProjectAccessTokenScopes: []string{"read"},
+		ActivateOnClaim:          true,
+	}
+	if err := setRole(context.Background(), s, role.Name, role); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// claimRequest returns a request claiming the token behind a handle
+func claimRequest(s logical.Storage, handle string) *logical.Request {
+	return &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "projectaccesstoken/claim",
+		Storage:   s,
+		Data:      map[string]interface{}{"handle": handle},
+	}
+}
+
+func TestClaimEnablesToken(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeProjectTokens()
+	// the first attempt to enable the token fails
+	failed := false
+	_, config := newFakeRollbar(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPatch && !failed {
+			failed = true
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fake.ServeHTTP(w, r)
+	})
+	b, s := newTestBackend(t, config)
+	setClaimRole(t, s)
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "projectaccesstoken/test",
+		Storage:   s,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("issuing token: %v %v", resp, err)
+	}
+	handle := resp.Data["claim_handle"].(string)
+	name := resp.Data["token_name"].(string)
+	if _, ok := resp.Data["project_access_token"]; ok {
+		t.Error("token returned before it was claimed")
+	}
+	if status, _ := fake.status(name); status != tokenStatusDisabled {
+		t.Fatalf("token issued with status %q, want disabled", status)
+	}
+
+	// a claim whose token could not be enabled can be retried
+	if _, err := b.HandleRequest(ctx, claimRequest(s, handle)); err == nil {
+		t.Fatal("expected the claim to fail while rollbar fails")
+	}
+
+	resp, err = b.HandleRequest(ctx, claimRequest(s, handle))
+	if err != nil || resp.IsError() {
+		t.Fatalf("claiming token: %v %v", resp, err)
+	}
+	if resp.Data["project_access_token"] != "pat-"+name {
+		t.Errorf("claim returned %v", resp.Data["project_access_token"])
+	}
+	if status, _ := fake.status(name); status != tokenStatusEnabled {
+		t.Errorf("claimed token has status %q, want enabled", status)
+	}
+
+	cred, err := getCredential(ctx, s, resp.Data["credential_id"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred == nil || cred.PendingClaim {
+		t.Errorf("credential after claim is %+v, want it no longer pending", cred)
+	}
+
+	resp, err = b.HandleRequest(ctx, claimRequest(s, handle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsError() {
+		t.Error("expected the handle to be rejected once used")
+	}
+}
+
+func TestExpireClaimsDeletesUnclaimedTokens(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeProjectTokens()
+	_, config := newFakeRollbar(t, fake.ServeHTTP)
+	b, s := newTestBackend(t, config)
+	setClaimRole(t, s)
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "projectaccesstoken/test",
+		Storage:   s,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("issuing token: %v %v", resp, err)
+	}
+	handle := resp.Data["claim_handle"].(string)
+	name := resp.Data["token_name"].(string)
+
+	// claims still within their window are left alone
+	if err := b.expireClaims(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.status(name); !ok {
+		t.Fatal("token deleted within its claim window")
+	}
+
+	claim, err := getClaim(ctx, s, claimKey(handle))
+	if err != nil || claim == nil {
+		t.Fatalf("reading claim: %v %v", claim, err)
+	}
+	claim.ExpiresAt = time.Now().Add(-time.Minute)
+	entry, err := logical.StorageEntryJSON(claimKey(handle), claim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.expireClaims(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.status(name); ok {
+		t.Error("unclaimed token left in rollbar")
+	}
+	if cred, err := getCredential(ctx, s, claim.CredentialID); err != nil || cred != nil {
+		t.Errorf("credential of unclaimed token is %+v, %v", cred, err)
+	}
+
+	resp, err = b.HandleRequest(ctx, claimRequest(s, handle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsError() {
+		t.Error("expected the expired handle to be rejected")
+	}
+}
@@ -1,24 +1,50 @@
 package plugin
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
+	"sync/atomic"
 	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"golang.org/x/time/rate"
 )
 
 const (
 	hostURL = "https://api.rollbar.com/api/1"
+
+	opCreate = "create"
+	opUpdate = "update"
+	opDelete = "delete"
+	opList   = "list"
+	opVerify = "verify"
+
+	tokenStatusEnabled  = "enabled"
+	tokenStatusDisabled = "disabled"
+
+	defaultClientRateLimit      = 10.0
+	defaultClientRateLimitBurst = 10
 )
 
 type rollbarClient struct {
 	client             *http.Client
 	hostURL            string
 	accountAccessToken string
+
+	// secondaryAccessToken is used in place of accountAccessToken once
+	// rollbar rejects the latter
+	secondaryAccessToken string
+	onSecondary          atomic.Bool
+
+	timeouts map[string]time.Duration
+	breaker  *circuitBreaker
+	stats    *apiStats
+	limiter  *rate.Limiter
 }
 
 func NewClient(config *RollbarConfig) (*rollbarClient, error) {
@@ -30,41 +56,194 @@ func NewClient(config *RollbarConfig) (*rollbarClient, error) {
 		return nil, errors.New("client account access token is not defined")
 	}
 
+	baseURL := config.APIURL
+	if baseURL == "" {
+		baseURL = hostURL
+	}
+
+	var limiter *rate.Limiter
+	if config.ClientRateLimit > 0 {
+		burst := config.ClientRateLimitBurst
+		if burst <= 0 {
+			burst = 1
+		}
+		limiter = rate.NewLimiter(rate.Limit(config.ClientRateLimit), burst)
+	}
+
 	return &rollbarClient{
-		client:             &http.Client{Timeout: 10 * time.Second},
-		hostURL:            hostURL,
-		accountAccessToken: config.AccountAccessToken,
+		client:               &http.Client{Timeout: 10 * time.Second},
+		hostURL:              baseURL,
+		accountAccessToken:   config.AccountAccessToken,
+		secondaryAccessToken: config.SecondaryAccountAccessToken,
+		timeouts: map[string]time.Duration{
+			opCreate: config.CreateTimeout,
+			// updates share the create timeout
+			opUpdate: config.CreateTimeout,
+			opDelete: config.DeleteTimeout,
+			opList:   config.ListTimeout,
+			opVerify: config.VerifyTimeout,
+		},
+		breaker: newCircuitBreaker(breakerSettings(config)),
+		limiter: limiter,
 	}, nil
 }
 
-func (c *rollbarClient) DoRequest(req *http.Request) ([]byte, error) {
-	req.Header.Set("X-Rollbar-Access-Token", c.accountAccessToken)
+// breakerSettings returns the circuit breaker settings of a config.
+// Configs written before the breaker existed store a threshold of 0 and
+// get the defaults.
+func breakerSettings(config *RollbarConfig) (int, time.Duration, int) {
+	threshold := config.BreakerFailureThreshold
+	if threshold == 0 {
+		threshold = defaultBreakerFailureThreshold
+	}
+	openDuration := config.BreakerOpenDuration
+	if openDuration == 0 {
+		openDuration = defaultBreakerOpenDuration
+	}
+
+	return threshold, openDuration, config.BreakerHalfOpenProbes
+}
+
+// emitRequestMetrics records the outcome and latency of a rollbar call,
+// counting canceled and timed out calls apart from failed ones
+func emitRequestMetrics(op string, start time.Time, err error) {
+	outcome := "success"
+	switch {
+	case err == nil:
+	case errors.Is(err, errRollbarCanceled):
+		outcome = "canceled"
+	case errors.Is(err, errRollbarTimeout):
+		outcome = "timeout"
+	case errors.Is(err, errRollbarUnavailable):
+		outcome = "rejected"
+	default:
+		outcome = "error"
+	}
+
+	labels := []metrics.Label{
+		{Name: "operation", Value: op},
+		{Name: "outcome", Value: outcome},
+	}
+	metrics.MeasureSinceWithLabels([]string{"rollbar", "api", "request"}, start, labels)
+	metrics.IncrCounterWithLabels([]string{"rollbar", "api", "request", outcome}, 1, labels)
+}
+
+// withTimeout derives a context bounded by the configured deadline of the
+// given operation, on top of any deadline already carried by ctx
+func (c *rollbarClient) withTimeout(ctx context.Context, op string) (context.Context, context.CancelFunc) {
+	if timeout := c.timeouts[op]; timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// activeCredential reports which account access token the client uses
+func (c *rollbarClient) activeCredential() string {
+	if c.onSecondary.Load() {
+		return "secondary"
+	}
+	return "primary"
+}
+
+func (c *rollbarClient) DoRequest(op string, req *http.Request) ([]byte, error) {
+	token := c.accountAccessToken
+	if c.onSecondary.Load() {
+		token = c.secondaryAccessToken
+	}
+	req.Header.Set("X-Rollbar-Access-Token", token)
+
+	if err := c.wait(req.Context()); err != nil {
+		emitRequestMetrics(op, time.Now(), err)
+		return nil, err
+	}
+
+	if err := c.breaker.allow(); err != nil {
+		emitRequestMetrics(op, time.Now(), err)
+		return nil, err
+	}
+
+	start := time.Now()
+	body, err := c.do(req)
+	if errors.Is(err, errRollbarUnauthorized) && !c.onSecondary.Load() && c.secondaryAccessToken != "" {
+		body, err = c.retryWithSecondary(req)
+	}
+	c.breaker.record(err)
+	c.stats.record(op, err)
+	emitRequestMetrics(op, start, err)
+
+	return body, err
+}
 
+// wait blocks until the client-side rate limiter allows another call
+func (c *rollbarClient) wait(ctx context.Context) error {
+	if c.limiter == nil {
+		return nil
+	}
+
+	if err := c.limiter.Wait(ctx); err != nil {
+		if ctx.Err() != nil {
+			return requestError(ctx, err)
+		}
+		// the limiter refuses to wait past the context deadline
+		return fmt.Errorf("%w: %v", errRollbarTimeout, err)
+	}
+
+	return nil
+}
+
+// retryWithSecondary replays a request rejected as unauthorized with the
+// secondary account access token, switching the client over to it when
+// rollbar accepts it
+func (c *rollbarClient) retryWithSecondary(req *http.Request) ([]byte, error) {
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	retry.Header.Set("X-Rollbar-Access-Token", c.secondaryAccessToken)
+
+	body, err := c.do(retry)
+	if err == nil {
+		c.onSecondary.Store(true)
+	}
+
+	return body, err
+}
+
+func (c *rollbarClient) do(req *http.Request) ([]byte, error) {
 	res, err := c.client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, requestError(req.Context(), err)
 	}
 	defer res.Body.Close()
 
+	c.stats.recordRateLimit(res.Header)
+
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
-		return nil, err
+		return nil, requestError(req.Context(), err)
 	}
 
 	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("status: %d, body: %s", res.StatusCode, body)
+		return nil, newRollbarAPIError(res.StatusCode, body)
 	}
 
-	return body, err
+	return body, nil
 }
 
 func (r *rollbarClient) deleteProjectAccessToken(ctx context.Context, projectID int, pat string) error {
+	ctx, cancel := r.withTimeout(ctx, opDelete)
+	defer cancel()
+
 	url := fmt.Sprintf("%s/project/%d/access_token/%s", r.hostURL, projectID, pat)
 
-	req, _ := http.NewRequest("DELETE", url, nil)
+	req, _ := http.NewRequestWithContext(ctx, "DELETE", url, nil)
 	req.Header.Add("accept", "application/json")
 
-	_, err := r.DoRequest(req)
+	_, err := r.DoRequest(opDelete, req)
 	if err != nil {
 		return err
 	}
@@ -72,12 +251,156 @@ func (r *rollbarClient) deleteProjectAccessToken(ctx context.Context, projectID
 	return nil
 }
 
-func (r *rollbarClient) CreateProjectAccessToken(ctx context.Context, scopes string, projectID int, name string) (*string, error) {
+func (r *rollbarClient) CreateProjectAccessToken(ctx context.Context, scopes []string, projectID int, name string) (*rollbarProjectAccessToken, error) {
+	return r.CreateProjectAccessTokenWithStatus(ctx, scopes, projectID, name, tokenStatusEnabled)
+}
+
+// CreateProjectAccessTokenWithStatus creates a project access token that
+// starts out with the given status
+func (r *rollbarClient) CreateProjectAccessTokenWithStatus(ctx context.Context, scopes []string, projectID int, name, status string) (*rollbarProjectAccessToken, error) {
+
+	ctx, cancel := r.withTimeout(ctx, opCreate)
+	defer cancel()
 
 	url := fmt.Sprintf("%s/project/%d/access_tokens", r.hostURL, projectID)
-	payload := strings.NewReader("{\"status\":\"enabled\",\"scopes\":[\"" + scopes + "\"],\"name\":\"" + name + "\"}")
 
-	req, err := http.NewRequest("POST", url, payload)
+	payload, err := json.Marshal(newCreateTokenRequest(name, status, scopes))
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("accept", "application/json")
+	req.Header.Add("content-type", "application/json")
+
+	resp := struct {
+		Result rollbarProjectAccessToken `json:"result"`
+	}{}
+
+	body, err := r.DoRequest(opCreate, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+
+	return &(resp.Result), nil
+}
+
+// createTokenRequest is the body of a request creating a project or
+// account access token
+type createTokenRequest struct {
+	Name   string   `json:"name"`
+	Status string   `json:"status"`
+	Scopes []string `json:"scopes"`
+}
+
+// newCreateTokenRequest returns the body of a request creating a token.
+// Rollbar expects one array element per scope, and an empty array rather
+// than null.
+func newCreateTokenRequest(name, status string, scopes []string) createTokenRequest {
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return createTokenRequest{Name: name, Status: status, Scopes: scopes}
+}
+
+// tokenStatusRequest is the body of a request enabling or disabling a
+// project access token
+type tokenStatusRequest struct {
+	Status string `json:"status"`
+}
+
+// SetProjectAccessTokenStatus enables or disables a project access token
+func (r *rollbarClient) SetProjectAccessTokenStatus(ctx context.Context, projectID int, pat, status string) error {
+	ctx, cancel := r.withTimeout(ctx, opUpdate)
+	defer cancel()
+
+	url := fmt.Sprintf("%s/project/%d/access_token/%s", r.hostURL, projectID, pat)
+	payload, err := json.Marshal(tokenStatusRequest{Status: status})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PATCH", url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("accept", "application/json")
+	req.Header.Add("content-type", "application/json")
+
+	_, err = r.DoRequest(opUpdate, req)
+	return err
+}
+
+// rollbarProjectAccessToken is a project access token as reported by
+// the rollbar API
+type rollbarProjectAccessToken struct {
+	ProjectID            int      `json:"project_id"`
+	AccessToken          string   `json:"access_token"`
+	Name                 string   `json:"name"`
+	Status               string   `json:"status"`
+	Scopes               []string `json:"scopes"`
+	RateLimitWindowSize  int      `json:"rate_limit_window_size"`
+	RateLimitWindowCount int      `json:"rate_limit_window_count"`
+	DateCreated          int64    `json:"date_created"`
+	DateModified         int64    `json:"date_modified"`
+}
+
+func (r *rollbarClient) ListProjectAccessTokens(ctx context.Context, projectID int) ([]rollbarProjectAccessToken, error) {
+	ctx, cancel := r.withTimeout(ctx, opList)
+	defer cancel()
+
+	url := fmt.Sprintf("%s/project/%d/access_tokens", r.hostURL, projectID)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("accept", "application/json")
+
+	resp := struct {
+		Result []rollbarProjectAccessToken `json:"result"`
+	}{}
+
+	body, err := r.DoRequest(opList, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Result, nil
+}
+
+// rollbarAccountAccessToken is an account access token as reported by
+// the rollbar API
+type rollbarAccountAccessToken struct {
+	AccessToken string   `json:"access_token"`
+	Name        string   `json:"name"`
+	Status      string   `json:"status"`
+	Scopes      []string `json:"scopes"`
+}
+
+func (r *rollbarClient) CreateAccountAccessToken(ctx context.Context, scopes []string, name string) (*string, error) {
+	ctx, cancel := r.withTimeout(ctx, opCreate)
+	defer cancel()
+
+	url := fmt.Sprintf("%s/account/access_tokens", r.hostURL)
+
+	payload, err := json.Marshal(newCreateTokenRequest(name, tokenStatusEnabled, scopes))
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
 	if err != nil {
 		return nil, err
 	}
@@ -90,7 +413,7 @@ func (r *rollbarClient) CreateProjectAccessToken(ctx context.Context, scopes str
 		} `json:"result"`
 	}{}
 
-	body, err := r.DoRequest(req)
+	body, err := r.DoRequest(opCreate, req)
 	if err != nil {
 		return nil, err
 	}
@@ -101,3 +424,101 @@ func (r *rollbarClient) CreateProjectAccessToken(ctx context.Context, scopes str
 
 	return &(resp.Result.AccessToken), nil
 }
+
+func (r *rollbarClient) deleteAccountAccessToken(ctx context.Context, aat string) error {
+	ctx, cancel := r.withTimeout(ctx, opDelete)
+	defer cancel()
+
+	url := fmt.Sprintf("%s/account/access_token/%s", r.hostURL, aat)
+
+	req, _ := http.NewRequestWithContext(ctx, "DELETE", url, nil)
+	req.Header.Add("accept", "application/json")
+
+	_, err := r.DoRequest(opDelete, req)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *rollbarClient) ListAccountAccessTokens(ctx context.Context) ([]rollbarAccountAccessToken, error) {
+	ctx, cancel := r.withTimeout(ctx, opList)
+	defer cancel()
+
+	url := fmt.Sprintf("%s/account/access_tokens", r.hostURL)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("accept", "application/json")
+
+	resp := struct {
+		Result []rollbarAccountAccessToken `json:"result"`
+	}{}
+
+	body, err := r.DoRequest(opList, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Result, nil
+}
+
+// rollbarProject is a project as reported by the rollbar API
+type rollbarProject struct {
+	ID        int    `json:"id"`
+	AccountID int    `json:"account_id"`
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+}
+
+func (r *rollbarClient) GetProject(ctx context.Context, projectID int) (*rollbarProject, error) {
+	ctx, cancel := r.withTimeout(ctx, opVerify)
+	defer cancel()
+
+	url := fmt.Sprintf("%s/project/%d", r.hostURL, projectID)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("accept", "application/json")
+
+	resp := struct {
+		Result rollbarProject `json:"result"`
+	}{}
+
+	body, err := r.DoRequest(opVerify, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+
+	return &(resp.Result), nil
+}
+
+// Ping checks that rollbar is reachable and accepts the account access token
+func (r *rollbarClient) Ping(ctx context.Context) error {
+	ctx, cancel := r.withTimeout(ctx, opVerify)
+	defer cancel()
+
+	url := fmt.Sprintf("%s/projects", r.hostURL)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("accept", "application/json")
+
+	_, err = r.DoRequest(opVerify, req)
+	return err
+}
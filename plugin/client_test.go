@@ -0,0 +1,339 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// newFakeRollbar starts a local server standing in for the rollbar API and
+// returns a config pointing at it
+func newFakeRollbar(t *testing.T, handler http.HandlerFunc) (*httptest.Server, *RollbarConfig) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	return server, &RollbarConfig{
+		AccountAccessToken: "account-token",
+		APIURL:             server.URL,
+	}
+}
+
+// fakeProjectTokens is a stateful stand-in for the project access token
+// endpoints of the rollbar API
+type fakeProjectTokens struct {
+	mu     sync.Mutex
+	tokens map[string]rollbarProjectAccessToken
+	scopes [][]string
+
+	// failCreate makes a create fail after rollbar stored the token, as
+	// when the response is lost
+	failCreate func(n int) bool
+	creates    int
+
+	// onCreate is called for every create, before the token is stored
+	onCreate func()
+}
+
+func newFakeProjectTokens() *fakeProjectTokens {
+	return &fakeProjectTokens{tokens: map[string]rollbarProjectAccessToken{}}
+}
+
+// names returns the names of the tokens rollbar holds
+func (f *fakeProjectTokens) names() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	names := []string{}
+	for _, token := range f.tokens {
+		names = append(names, token.Name)
+	}
+	return names
+}
+
+// status returns the status of the token with the given name and whether
+// it exists
+func (f *fakeProjectTokens) status(name string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, token := range f.tokens {
+		if token.Name == name {
+			return token.Status, true
+		}
+	}
+	return "", false
+}
+
+func (f *fakeProjectTokens) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/access_tokens"):
+		body := struct {
+			Name   string   `json:"name"`
+			Status string   `json:"status"`
+			Scopes []string `json:"scopes"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if f.onCreate != nil {
+			f.onCreate()
+		}
+		f.creates++
+		f.scopes = append(f.scopes, body.Scopes)
+		token := rollbarProjectAccessToken{
+			AccessToken: "pat-" + body.Name,
+			Name:        body.Name,
+			Status:      body.Status,
+			Scopes:      body.Scopes,
+		}
+		f.tokens[token.AccessToken] = token
+		if f.failCreate != nil && f.failCreate(f.creates) {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"err": 0, "result": token})
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/access_tokens"):
+		result := []rollbarProjectAccessToken{}
+		for _, token := range f.tokens {
+			result = append(result, token)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"err": 0, "result": result})
+	case r.Method == http.MethodPatch:
+		pat := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		token, ok := f.tokens[pat]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body := struct {
+			Status string `json:"status"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		token.Status = body.Status
+		f.tokens[pat] = token
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"err": 0})
+	case r.Method == http.MethodDelete:
+		pat := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		if _, ok := f.tokens[pat]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.tokens, pat)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"err": 0})
+	case r.Method == http.MethodGet:
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"err": 0, "result": rollbarProject{ID: 1, Name: "project"}})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// respond returns a handler answering every request with the given status
+// and body, after an optional delay
+func respond(status int, body string, delay time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}
+}
+
+func TestClientUsesConfiguredAPIURL(t *testing.T) {
+	var gotPath, gotToken string
+	_, config := newFakeRollbar(t, func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotToken = r.Header.Get("X-Rollbar-Access-Token")
+		_, _ = w.Write([]byte(`{"err":0,"result":{}}`))
+	})
+
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.deleteProjectAccessToken(context.Background(), 12, "tok"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if gotPath != "/project/12/access_token/tok" {
+		t.Errorf("request sent to %q", gotPath)
+	}
+	if gotToken != "account-token" {
+		t.Errorf("request sent with access token %q", gotToken)
+	}
+}
+
+func TestClientDeleteProjectAccessTokenErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		handler http.HandlerFunc
+		closed  bool
+		want    error
+	}{
+		{
+			name:    "not found",
+			handler: respond(http.StatusNotFound, `{"err":1,"message":"Not found"}`, 0),
+			want:    errRollbarNotFound,
+		},
+		{
+			name:    "server error",
+			handler: respond(http.StatusBadGateway, `{"err":1,"message":"Bad gateway"}`, 0),
+			want:    errRollbarServer,
+		},
+		{
+			name:    "timeout",
+			handler: respond(http.StatusOK, `{"err":0}`, time.Second),
+			want:    errRollbarTimeout,
+		},
+		{
+			name:    "connection refused",
+			handler: respond(http.StatusOK, `{"err":0}`, 0),
+			closed:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, config := newFakeRollbar(t, tc.handler)
+			config.DeleteTimeout = 50 * time.Millisecond
+			if tc.closed {
+				server.Close()
+			}
+
+			client, err := NewClient(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = client.deleteProjectAccessToken(context.Background(), 1, "tok")
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if tc.want != nil && !errors.Is(err, tc.want) {
+				t.Errorf("got error %v, want %v", err, tc.want)
+			}
+			if tc.closed && (errors.Is(err, errRollbarNotFound) || errors.Is(err, errRollbarCanceled)) {
+				t.Errorf("connection refused reported as %v", err)
+			}
+		})
+	}
+}
+
+func TestClientFailsOverToSecondaryToken(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeProjectTokens()
+	var mu sync.Mutex
+	used := map[string]int{}
+	_, config := newFakeRollbar(t, func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-Rollbar-Access-Token")
+		mu.Lock()
+		used[token]++
+		mu.Unlock()
+		// the primary token has been revoked in rollbar
+		if token != "secondary-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"err":1,"message":"invalid access token"}`))
+			return
+		}
+		fake.ServeHTTP(w, r)
+	})
+	config.SecondaryAccountAccessToken = "secondary-token"
+	b, s := newTestBackend(t, config)
+
+	role := &RollbarRoleEntry{
+		Name:                     "test",
+		RoleType:                 roleTypeProject,
+		ProjectID:                1,
+		ProjectAccessTokenScopes: []string{"read"},
+	}
+	if err := setRole(ctx, s, role.Name, role); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "projectaccesstoken/test",
+			Storage:   s,
+		})
+		if err != nil || resp.IsError() {
+			t.Fatalf("issuing token: %v %v", resp, err)
+		}
+	}
+
+	// the client stays on the secondary token once it was accepted
+	mu.Lock()
+	if used["account-token"] != 1 {
+		t.Errorf("primary token used %d times, want once", used["account-token"])
+	}
+	mu.Unlock()
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "status",
+		Storage:   s,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("reading status: %v %v", resp, err)
+	}
+	if active := resp.Data["active_account_access_token"]; active != "secondary" {
+		t.Errorf("status reports %v account access token in use, want secondary", active)
+	}
+	if breaker := resp.Data["breaker"].(map[string]interface{}); breaker["state"] != breakerClosed {
+		t.Errorf("rejected primary token moved breaker to %v", breaker["state"])
+	}
+}
+
+func TestClientRequestBodies(t *testing.T) {
+	var bodies []string
+	_, config := newFakeRollbar(t, func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(raw))
+		_, _ = w.Write([]byte(`{"err":0,"result":{"access_token":"token"}}`))
+	})
+
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if _, err := client.CreateAccountAccessToken(ctx, nil, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetProjectAccessTokenStatus(ctx, 1, "pat", tokenStatusEnabled); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		`{"name":"test","status":"enabled","scopes":[]}`,
+		`{"status":"enabled"}`,
+	}
+	if !reflect.DeepEqual(bodies, want) {
+		t.Errorf("rollbar received %q, want %q", bodies, want)
+	}
+}
@@ -0,0 +1,132 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+var (
+	errRollbarUnauthorized = errors.New("rollbar rejected the account access token")
+	errRollbarForbidden    = errors.New("rollbar denied access to the resource")
+	errRollbarNotFound     = errors.New("rollbar resource not found")
+	errRollbarRateLimited  = errors.New("rollbar rate limit exceeded")
+	errRollbarValidation   = errors.New("rollbar rejected the request")
+	errRollbarServer       = errors.New("rollbar server error")
+	errRollbarUnexpected   = errors.New("unexpected rollbar response")
+	errRollbarCanceled     = errors.New("rollbar request canceled")
+	errRollbarTimeout      = errors.New("rollbar request timed out")
+	errRollbarUnavailable  = errors.New("rollbar unavailable: circuit breaker is open")
+)
+
+// rollbarAPIError is a failed call to the rollbar API. It carries the
+// message from rollbar's error envelope but never the raw response body.
+type rollbarAPIError struct {
+	StatusCode int
+	Message    string
+	kind       error
+}
+
+func (e *rollbarAPIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s (status %d)", e.kind, e.StatusCode)
+	}
+	return fmt.Sprintf("%s (status %d): %s", e.kind, e.StatusCode, e.Message)
+}
+
+func (e *rollbarAPIError) Unwrap() error {
+	return e.kind
+}
+
+// newRollbarAPIError builds a typed error from a non-success rollbar
+// response, reading the message from its {err, message} envelope
+func newRollbarAPIError(statusCode int, body []byte) *rollbarAPIError {
+	envelope := struct {
+		Err     int    `json:"err"`
+		Message string `json:"message"`
+	}{}
+	_ = json.Unmarshal(body, &envelope)
+
+	apiErr := &rollbarAPIError{
+		StatusCode: statusCode,
+		Message:    envelope.Message,
+	}
+
+	switch {
+	case statusCode == http.StatusUnauthorized:
+		apiErr.kind = errRollbarUnauthorized
+	case statusCode == http.StatusForbidden:
+		apiErr.kind = errRollbarForbidden
+	case statusCode == http.StatusNotFound:
+		apiErr.kind = errRollbarNotFound
+	case statusCode == http.StatusTooManyRequests:
+		apiErr.kind = errRollbarRateLimited
+	case statusCode == http.StatusBadRequest, statusCode == http.StatusUnprocessableEntity, statusCode == http.StatusConflict:
+		apiErr.kind = errRollbarValidation
+	case statusCode >= http.StatusInternalServerError:
+		apiErr.kind = errRollbarServer
+	default:
+		apiErr.kind = errRollbarUnexpected
+	}
+
+	return apiErr
+}
+
+// requestError distinguishes a rollbar call abandoned because its context
+// was canceled or ran past its deadline from other transport failures
+func requestError(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		return fmt.Errorf("%w: %v", errRollbarCanceled, err)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%w: %v", errRollbarTimeout, err)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w: %v", errRollbarTimeout, err)
+	}
+
+	return err
+}
+
+// rollbarCodedError maps an error returned by the rollbar client onto a
+// Vault error carrying the matching HTTP status code, so callers can tell
+// a misconfigured role apart from rollbar being unavailable
+func rollbarCodedError(err error, action string) error {
+	switch {
+	case errors.Is(err, errRollbarTimeout):
+		return logical.CodedError(http.StatusGatewayTimeout, fmt.Sprintf("%s: %s", action, err))
+	case errors.Is(err, errRollbarUnavailable):
+		return logical.CodedError(http.StatusServiceUnavailable, fmt.Sprintf("%s: %s", action, err))
+	case errors.Is(err, errRollbarCanceled):
+		return fmt.Errorf("%s: %w", action, err)
+	}
+
+	var apiErr *rollbarAPIError
+	if !errors.As(err, &apiErr) {
+		return fmt.Errorf("%s: %w", action, err)
+	}
+
+	msg := fmt.Sprintf("%s: %s", action, apiErr)
+
+	switch {
+	case errors.Is(err, errRollbarValidation):
+		return logical.CodedError(http.StatusBadRequest, msg)
+	case errors.Is(err, errRollbarNotFound):
+		return logical.CodedError(http.StatusNotFound, msg)
+	case errors.Is(err, errRollbarRateLimited):
+		return logical.CodedError(http.StatusTooManyRequests, msg)
+	case errors.Is(err, errRollbarServer):
+		return logical.CodedError(http.StatusServiceUnavailable, msg)
+	default:
+		// rollbar refusing the mount's account access token, or answering
+		// in a way we don't understand, is an upstream fault
+		return logical.CodedError(http.StatusBadGateway, msg)
+	}
+}
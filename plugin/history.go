@@ -0,0 +1,296 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	historyStoragePath      = "history/"
+	defaultHistoryRetention = 30 * 24 * time.Hour
+
+	eventIssue    = "issue"
+	eventRenew    = "renew"
+	eventRevoke   = "revoke"
+	eventRollback = "rollback"
+	eventFailure  = "failure"
+
+	outcomeSuccess = "success"
+)
+
+// historyEvent is a record of a token being issued, renewed, revoked or
+// rolled back, or of one of these operations failing. Events are kept after
+// the token is gone so past issuance can be reviewed.
+type historyEvent struct {
+	ID                string    `json:"id"`
+	Type              string    `json:"type"`
+	Operation         string    `json:"operation,omitempty"`
+	Outcome           string    `json:"outcome"`
+	Role              string    `json:"role"`
+	ProjectID         int       `json:"project_id"`
+	TokenName         string    `json:"token_name"`
+	CredentialID      string    `json:"credential_id"`
+	LeaseID           string    `json:"lease_id"`
+	EntityID          string    `json:"entity_id"`
+	EntityDisplayName string    `json:"entity_display_name"`
+	Time              time.Time `json:"time"`
+}
+
+// credentialEvent returns an event about the token behind an inventory
+// record. The entity is the one the token was issued to.
+func credentialEvent(eventType string, cred *RollbarCredentialEntry) *historyEvent {
+	return &historyEvent{
+		Type:              eventType,
+		Outcome:           outcomeSuccess,
+		Role:              cred.Role,
+		ProjectID:         cred.ProjectID,
+		TokenName:         cred.TokenName,
+		CredentialID:      cred.ID,
+		LeaseID:           cred.LeaseID,
+		EntityID:          cred.EntityID,
+		EntityDisplayName: cred.EntityDisplayName,
+	}
+}
+
+// failed turns an event into the failure event of its operation
+func (e *historyEvent) failed(err error) *historyEvent {
+	e.Operation = e.Type
+	e.Type = eventFailure
+	e.Outcome = err.Error()
+	return e
+}
+
+// historyKey returns the storage key of an event. Keys start with the
+// zero padded event time so they sort chronologically and can be pruned
+// without being read.
+func historyKey(e *historyEvent) string {
+	return fmt.Sprintf("%s%020d-%s", historyStoragePath, e.Time.UnixNano(), e.ID)
+}
+
+// historyKeyTime returns the time of an event from its storage key
+func historyKeyTime(key string) (time.Time, bool) {
+	prefix, _, ok := strings.Cut(key, "-")
+	if !ok {
+		return time.Time{}, false
+	}
+
+	nanos, err := strconv.ParseInt(prefix, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, nanos).UTC(), true
+}
+
+// recordEvent appends an event to the issuance history. History is kept on
+// a best effort basis: a failure to record an event is logged and does not
+// fail the operation it describes.
+func (b *RollbarBackend) recordEvent(ctx context.Context, s logical.Storage, e *historyEvent) {
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		b.Logger().Warn("error generating history event ID", "error", err)
+		return
+	}
+
+	e.ID = id
+	e.Time = time.Now().UTC()
+
+	entry, err := logical.StorageEntryJSON(historyKey(e), e)
+	if err == nil {
+		err = s.Put(ctx, entry)
+	}
+	if err != nil {
+		b.Logger().Warn("error recording history event", "type", e.Type, "credential_id", e.CredentialID, "error", err)
+	}
+}
+
+// withIssueHistory records a failure event when an issuance request fails.
+// Successful issuance is recorded along with the inventory record.
+func (b *RollbarBackend) withIssueHistory(cb framework.OperationFunc) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		resp, err := cb(ctx, req, d)
+
+		if err == nil && (resp == nil || !resp.IsError()) {
+			return resp, err
+		}
+
+		e := &historyEvent{
+			Type:              eventIssue,
+			Role:              d.Get("name").(string),
+			EntityID:          req.EntityID,
+			EntityDisplayName: req.DisplayName,
+		}
+		if roleEntry, roleErr := b.getRole(ctx, req.Storage, e.Role); roleErr == nil && roleEntry != nil {
+			e.ProjectID = roleEntry.ProjectID
+		}
+
+		failure := err
+		if failure == nil {
+			failure = resp.Error()
+		}
+		b.recordEvent(ctx, req.Storage, e.failed(failure))
+
+		return resp, err
+	}
+}
+
+// withSecretHistory records an event for every token of a secret when it is
+// renewed or revoked, or a failure event when the operation fails. The
+// inventory records are read beforehand as revocation removes them.
+func (b *RollbarBackend) withSecretHistory(eventType string, cb framework.OperationFunc) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		events := b.secretEvents(ctx, req, eventType)
+
+		resp, err := cb(ctx, req, d)
+
+		for _, e := range events {
+			if err != nil {
+				e.failed(err)
+			}
+			b.recordEvent(ctx, req.Storage, e)
+		}
+
+		return resp, err
+	}
+}
+
+// secretEvents returns an event for every token of the secret of a renew or
+// revoke request, built from their inventory records. Tokens whose record is
+// gone were revoked out of band and their revocation is already recorded.
+func (b *RollbarBackend) secretEvents(ctx context.Context, req *logical.Request, eventType string) []*historyEvent {
+	ids := []string{}
+	if id, _ := req.Secret.InternalData["credential_id"].(string); id != "" {
+		ids = append(ids, id)
+	}
+	if _, ok := req.Secret.InternalData["tokens"]; ok {
+		tokens, err := secretBatchTokens(req.Secret)
+		if err == nil {
+			for _, token := range tokens {
+				ids = append(ids, token.CredentialID)
+			}
+		}
+	}
+
+	role, _ := req.Secret.InternalData["role"].(string)
+	projectID, _ := b.secretProjectID(ctx, req)
+
+	events := make([]*historyEvent, 0, len(ids))
+	for _, id := range ids {
+		cred, err := getCredential(ctx, req.Storage, id)
+		if err == nil && cred == nil && eventType == eventRevoke {
+			continue
+		}
+		if err != nil || cred == nil {
+			cred = &RollbarCredentialEntry{
+				ID:        id,
+				Role:      role,
+				ProjectID: projectID,
+			}
+		}
+		e := credentialEvent(eventType, cred)
+		e.LeaseID = req.Secret.LeaseID
+		events = append(events, e)
+	}
+
+	if len(ids) == 0 {
+		events = append(events, &historyEvent{
+			Type:      eventType,
+			Outcome:   outcomeSuccess,
+			Role:      role,
+			ProjectID: projectID,
+			LeaseID:   req.Secret.LeaseID,
+		})
+	}
+
+	return events
+}
+
+// pruneHistory deletes the events older than the configured retention
+func (b *RollbarBackend) pruneHistory(ctx context.Context, s logical.Storage) error {
+	config, err := getConfig(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	retention := defaultHistoryRetention
+	if config != nil {
+		retention = config.HistoryRetention
+	}
+
+	if retention <= 0 {
+		return nil
+	}
+
+	keys, err := s.List(ctx, historyStoragePath)
+	if err != nil {
+		return err
+	}
+	sort.Strings(keys)
+
+	cutoff := time.Now().Add(-retention)
+	pruned := 0
+	for _, k := range keys {
+		at, ok := historyKeyTime(k)
+		if !ok {
+			continue
+		}
+		// keys sort chronologically so the rest are newer
+		if at.After(cutoff) {
+			break
+		}
+		if err := s.Delete(ctx, historyStoragePath+k); err != nil {
+			return err
+		}
+		pruned++
+	}
+
+	if pruned > 0 {
+		b.Logger().Debug("pruned issuance history", "events", pruned)
+	}
+
+	return nil
+}
+
+// getHistoryEvent gets a history event from the Vault storage API
+func getHistoryEvent(ctx context.Context, s logical.Storage, key string) (*historyEvent, error) {
+	entry, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	e := new(historyEvent)
+	if err := entry.DecodeJSON(e); err != nil {
+		return nil, fmt.Errorf("error reading history event: %w", err)
+	}
+
+	return e, nil
+}
+
+// toResponseData returns response data for a history event
+func (e *historyEvent) toResponseData() map[string]interface{} {
+	return map[string]interface{}{
+		"type":                e.Type,
+		"operation":           e.Operation,
+		"outcome":             e.Outcome,
+		"role":                e.Role,
+		"project_id":          e.ProjectID,
+		"token_name":          e.TokenName,
+		"credential_id":       e.CredentialID,
+		"lease_id":            e.LeaseID,
+		"entity_id":           e.EntityID,
+		"entity_display_name": e.EntityDisplayName,
+		"time":                e.Time,
+	}
+}
@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// putEvents stores history events with the times they carry and returns
+// their keys
+func putEvents(t *testing.T, s logical.Storage, events ...*historyEvent) []string {
+	t.Helper()
+
+	keys := make([]string, 0, len(events))
+	for i, e := range events {
+		e.ID = string(rune('a' + i))
+		entry, err := logical.StorageEntryJSON(historyKey(e), e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Put(context.Background(), entry); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, historyKey(e)[len(historyStoragePath):])
+	}
+	return keys
+}
+
+func TestHistoryListFilters(t *testing.T) {
+	ctx := context.Background()
+	b, s := newTestBackend(t, nil)
+
+	now := time.Now().UTC()
+	keys := putEvents(t, s,
+		&historyEvent{Type: eventIssue, Role: "web", ProjectID: 1, EntityID: "alice", Time: now.Add(-3 * time.Hour)},
+		&historyEvent{Type: eventRevoke, Role: "web", ProjectID: 1, EntityID: "alice", Time: now.Add(-2 * time.Hour)},
+		&historyEvent{Type: eventIssue, Role: "ci", ProjectID: 2, EntityID: "bob", Time: now.Add(-time.Hour)},
+		&historyEvent{Type: eventIssue, Role: "web", ProjectID: 1, EntityID: "bob", Time: now},
+	)
+
+	cases := []struct {
+		name string
+		data map[string]interface{}
+		want []string
+	}{
+		{name: "all", data: map[string]interface{}{}, want: keys},
+		{name: "type", data: map[string]interface{}{"type": eventIssue}, want: []string{keys[0], keys[2], keys[3]}},
+		{name: "role", data: map[string]interface{}{"role": "ci"}, want: []string{keys[2]}},
+		{name: "project", data: map[string]interface{}{"project_id": 1}, want: []string{keys[0], keys[1], keys[3]}},
+		{name: "entity", data: map[string]interface{}{"entity_id": "bob"}, want: []string{keys[2], keys[3]}},
+		{name: "range", data: map[string]interface{}{
+			"start": now.Add(-150 * time.Minute).Format(time.RFC3339),
+			"end":   now.Add(-30 * time.Minute).Format(time.RFC3339),
+		}, want: []string{keys[1], keys[2]}},
+		{name: "page", data: map[string]interface{}{"after": keys[0], "limit": 2}, want: []string{keys[1], keys[2]}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Operation: logical.ListOperation,
+				Path:      "history/",
+				Storage:   s,
+				Data:      tc.data,
+			})
+			if err != nil || resp.IsError() {
+				t.Fatalf("listing history: %v %v", resp, err)
+			}
+			got, _ := resp.Data["keys"].([]string)
+			if len(got) == 0 {
+				got = []string{}
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("listed %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPruneHistory(t *testing.T) {
+	cases := []struct {
+		name      string
+		retention time.Duration
+		kept      int
+	}{
+		{name: "retention", retention: 24 * time.Hour, kept: 1},
+		{name: "forever", retention: 0, kept: 2},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			b, s := newTestBackend(t, &RollbarConfig{
+				AccountAccessToken: "account-token",
+				HistoryRetention:   tc.retention,
+			})
+
+			now := time.Now().UTC()
+			putEvents(t, s,
+				&historyEvent{Type: eventIssue, Role: "web", Time: now.Add(-48 * time.Hour)},
+				&historyEvent{Type: eventIssue, Role: "web", Time: now.Add(-time.Hour)},
+			)
+
+			if err := b.pruneHistory(ctx, s); err != nil {
+				t.Fatal(err)
+			}
+
+			keys, err := s.List(ctx, historyStoragePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != tc.kept {
+				t.Errorf("%d events kept, want %d", len(keys), tc.kept)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	accountAccessTokenPath        = "accountaccesstoken/"
+	pathAccountAccessTokenHelpSyn = `
+	Generate a rollbar account access token from an account role.
+	`
+	pathAccountAccessTokenDesc = `
+	This path generates a short-lived rollbar account access token based on a
+	role with role_type=account. The token is deleted from rollbar when its
+	lease is revoked.
+	`
+)
+
+func pathAccountAccessToken(b *RollbarBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: accountAccessTokenPath + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "Name of the role",
+				Required:    true,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.withIssueHistory(b.pathAccountAccessTokenRead),
+			logical.UpdateOperation: b.withIssueHistory(b.pathAccountAccessTokenRead),
+		},
+		HelpSynopsis:    pathAccountAccessTokenHelpSyn,
+		HelpDescription: pathAccountAccessTokenDesc,
+	}
+}
+
+func (b *RollbarBackend) pathAccountAccessTokenRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	roleName := d.Get("name").(string)
+
+	roleEntry, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving role: %w", err)
+	}
+
+	if roleEntry == nil {
+		return logical.ErrorResponse("role %q not found", roleName), nil
+	}
+
+	if roleEntry.RoleType != roleTypeAccount {
+		return logical.ErrorResponse("role %q issues %s access tokens", roleName, roleEntry.RoleType), nil
+	}
+
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
+	}
+
+	if req.EntityID != "" {
+		unlockQuota := b.lockQuota(roleName, req.EntityID)
+		defer unlockQuota()
+
+		if resp, err := b.checkEntityQuota(ctx, req.Storage, roleEntry, req.EntityID, 1); err != nil || resp != nil {
+			return resp, err
+		}
+	}
+
+	reservations, resp, err := b.reserveTokens(ctx, req.Storage, roleEntry, roleName+"-", 1, false)
+	if err != nil || resp != nil {
+		return resp, err
+	}
+	reservation := reservations[0]
+	credID := reservation.CredentialID
+	aatName := reservation.TokenName
+
+	aat, err := createAccountAccessToken(ctx, client, roleEntry.AccountAccessTokenScopes, aatName)
+	if err != nil {
+		b.releaseFailedCreate(ctx, req.Storage, err, reservation)
+		return nil, rollbarCodedError(err, "error creating account access token")
+	}
+	if aat == nil || len(*aat) == 0 {
+		b.releaseUnconfirmed(ctx, req.Storage, reservation)
+		return nil, errors.New("error creating account access token: rollbar returned no token")
+	}
+
+	resp = b.Secret(rollbarAccountAccessTokenType).Response(map[string]interface{}{
+		"account_access_token": *aat,
+	}, map[string]interface{}{
+		"account_access_token": *aat,
+		"role":                 roleEntry.Name,
+		"credential_id":        credID,
+	})
+	roleEntry.annotate(resp)
+
+	if roleEntry.TTL > 0 {
+		resp.Secret.TTL = roleEntry.TTL
+	}
+
+	if roleEntry.MaxTTL > 0 {
+		resp.Secret.MaxTTL = roleEntry.MaxTTL
+	}
+
+	cred := &RollbarCredentialEntry{
+		ID:        credID,
+		Type:      roleTypeAccount,
+		Role:      roleEntry.Name,
+		Scopes:    roleEntry.scopes(),
+		TokenName: aatName,
+		Owner:     roleEntry.Owner,
+		Metadata:  roleEntry.Metadata,
+	}
+	if err := b.recordCredential(ctx, req, resp, cred); err != nil {
+		b.Logger().Error("error recording issued credential, deleting account access token", "name", aatName, "error", err)
+		if delErr := deleteAccountAccessToken(ctx, client, *aat); delErr != nil {
+			b.Logger().Error("error deleting unrecorded account access token", "name", aatName, "error", delErr)
+		} else {
+			b.releaseReservations(ctx, req.Storage, reservation)
+		}
+		return nil, fmt.Errorf("error recording issued credential: %w", err)
+	}
+	b.commitReservations(ctx, req.Storage, reservation)
+
+	if err := recordEntityIssuance(ctx, req.Storage, roleEntry, req.EntityID, 1); err != nil {
+		b.Logger().Warn("error recording entity issuance", "role", roleName, "error", err)
+	}
+
+	return resp, nil
+}
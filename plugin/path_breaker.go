@@ -0,0 +1,45 @@
+package plugin
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	pathBreakerDef             = "breaker"
+	pathBreakerHelpSynopsis    = "Read the state of the circuit breaker around the rollbar API."
+	pathBreakerHelpDescription = `
+	This path reports whether calls to the rollbar API currently go through
+	(closed), fail fast (open) or are being probed (half-open), along with the
+	breaker's consecutive failure count and settings.
+	`
+)
+
+func pathBreaker(b *RollbarBackend) *framework.Path {
+
+	return &framework.Path{
+		Pattern: pathBreakerDef,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathBreakerRead,
+			},
+		},
+		HelpSynopsis:    pathBreakerHelpSynopsis,
+		HelpDescription: pathBreakerHelpDescription,
+	}
+}
+
+// pathBreakerRead returns the circuit breaker state of the rollbar client
+func (b *RollbarBackend) pathBreakerRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return logical.ErrorResponse("backend is not configured: %s", err), nil
+	}
+
+	return &logical.Response{
+		Data: client.breaker.snapshot().toResponseData(),
+	}, nil
+}
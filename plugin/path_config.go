@@ -4,6 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -11,6 +14,7 @@ import (
 
 const (
 	pathConfigDef             = "config"
+	pathConfigPromoteDef      = "config/promote"
 	configStoragePath         = "config"
 	pathConfigHelpSynopsis    = "Configure the rollbar backend"
 	pathConfigHelpDescription = `
@@ -18,11 +22,43 @@ const (
 	project access tokens.
 
 	You must provide a read, write scoped account access token.
+
+	A secondary account access token can be configured alongside it. The backend
+	falls back to it when rollbar rejects the primary token, and writing to
+	config/promote verifies it with rollbar and swaps it with the primary.
+	`
+	pathConfigPromoteHelpSynopsis    = "Promote the secondary account access token to primary"
+	pathConfigPromoteHelpDescription = `
+	Verifies the secondary account access token with rollbar, then makes it the
+	primary token. The previous primary token becomes the secondary one so it can
+	be removed once nothing depends on it.
 	`
 )
 
 type RollbarConfig struct {
-	AccountAccessToken string `json:"account_access_token"`
+	AccountAccessToken          string        `json:"account_access_token"`
+	SecondaryAccountAccessToken string        `json:"secondary_account_access_token"`
+	APIURL                      string        `json:"api_url"`
+	ReconcileInterval           time.Duration `json:"reconcile_interval"`
+	CreateTimeout               time.Duration `json:"create_timeout"`
+	DeleteTimeout               time.Duration `json:"delete_timeout"`
+	ListTimeout                 time.Duration `json:"list_timeout"`
+	VerifyTimeout               time.Duration `json:"verify_timeout"`
+
+	BreakerFailureThreshold int           `json:"breaker_failure_threshold"`
+	BreakerOpenDuration     time.Duration `json:"breaker_open_duration"`
+	BreakerHalfOpenProbes   int           `json:"breaker_half_open_probes"`
+
+	HistoryVersions  int           `json:"history_versions"`
+	HistoryRetention time.Duration `json:"history_retention"`
+
+	ClientRateLimit      float64 `json:"client_rate_limit"`
+	ClientRateLimitBurst int     `json:"client_rate_limit_burst"`
+	SchemaVersion        int     `json:"schema_version"`
+
+	// absent holds the fields missing from a stored config, so migrations
+	// can tell fields that predate it from fields set to zero
+	absent map[string]bool
 }
 
 func pathConfig(b *RollbarBackend) *framework.Path {
@@ -39,6 +75,74 @@ func pathConfig(b *RollbarBackend) *framework.Path {
 					Sensitive: true,
 				},
 			},
+			"secondary_account_access_token": {
+				Type:        framework.TypeString,
+				Description: "Optional. A second Account Access Token used when rollbar rejects the primary one, and promoted to primary through config/promote",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "Secondary Account Access Token",
+					Sensitive: true,
+				},
+			},
+			"api_url": {
+				Type:        framework.TypeString,
+				Description: "Optional. Base URL of the rollbar API. Defaults to " + hostURL + ".",
+			},
+			"reconcile_interval": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Optional. Interval between reconciliations of issued tokens against rollbar. Set to 0 to disable the periodic job.",
+				Default:     int(defaultReconcileInterval.Seconds()),
+			},
+			"create_timeout": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Optional. Deadline for rollbar calls creating tokens. If not set or set to 0, only the request deadline and client timeout apply.",
+			},
+			"delete_timeout": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Optional. Deadline for rollbar calls deleting tokens. If not set or set to 0, only the request deadline and client timeout apply.",
+			},
+			"list_timeout": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Optional. Deadline for rollbar calls listing tokens. If not set or set to 0, only the request deadline and client timeout apply.",
+			},
+			"verify_timeout": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Optional. Deadline for rollbar calls verifying tokens and looking up projects. If not set or set to 0, only the request deadline and client timeout apply.",
+			},
+			"breaker_failure_threshold": {
+				Type:        framework.TypeInt,
+				Description: "Optional. Consecutive rollbar failures that open the circuit breaker. Set to a negative value to disable the breaker, 0 is not accepted.",
+				Default:     defaultBreakerFailureThreshold,
+			},
+			"breaker_open_duration": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Optional. Time the circuit breaker stays open before probing rollbar again.",
+				Default:     int(defaultBreakerOpenDuration.Seconds()),
+			},
+			"breaker_half_open_probes": {
+				Type:        framework.TypeInt,
+				Description: "Optional. Successful probe calls needed to close the circuit breaker again.",
+				Default:     defaultBreakerHalfOpenProbes,
+			},
+			"history_versions": {
+				Type:        framework.TypeInt,
+				Description: "Optional. Number of past versions of the config and of each role kept in history.",
+				Default:     defaultHistoryVersions,
+			},
+			"history_retention": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Optional. Time issuance history events are kept. Set to 0 to keep them forever.",
+				Default:     int(defaultHistoryRetention.Seconds()),
+			},
+			"client_rate_limit": {
+				Type:        framework.TypeFloat,
+				Description: "Optional. Maximum rollbar API calls per second made by the backend. Set to 0 to disable client-side rate limiting.",
+				Default:     defaultClientRateLimit,
+			},
+			"client_rate_limit_burst": {
+				Type:        framework.TypeInt,
+				Description: "Optional. Number of rollbar API calls allowed in a burst above client_rate_limit.",
+				Default:     defaultClientRateLimitBurst,
+			},
 		},
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.CreateOperation: &framework.PathOperation{
@@ -60,6 +164,20 @@ func pathConfig(b *RollbarBackend) *framework.Path {
 	}
 }
 
+func pathConfigPromote(b *RollbarBackend) *framework.Path {
+
+	return &framework.Path{
+		Pattern: pathConfigPromoteDef,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathConfigPromote,
+			},
+		},
+		HelpSynopsis:    pathConfigPromoteHelpSynopsis,
+		HelpDescription: pathConfigPromoteHelpDescription,
+	}
+}
+
 func (b *RollbarBackend) pathConfigRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	config, err := getConfig(ctx, req.Storage)
 	if err != nil {
@@ -68,7 +186,24 @@ func (b *RollbarBackend) pathConfigRead(ctx context.Context, req *logical.Reques
 
 	return &logical.Response{
 		Data: map[string]interface{}{
-			"account_access_token": config.AccountAccessToken,
+			"account_access_token":               config.AccountAccessToken,
+			"secondary_account_access_token_set": config.SecondaryAccountAccessToken != "",
+			"api_url":                            config.APIURL,
+			"reconcile_interval":                 int64(config.ReconcileInterval.Seconds()),
+			"create_timeout":                     int64(config.CreateTimeout.Seconds()),
+			"delete_timeout":                     int64(config.DeleteTimeout.Seconds()),
+			"list_timeout":                       int64(config.ListTimeout.Seconds()),
+			"verify_timeout":                     int64(config.VerifyTimeout.Seconds()),
+
+			"breaker_failure_threshold": config.BreakerFailureThreshold,
+			"breaker_open_duration":     int64(config.BreakerOpenDuration.Seconds()),
+			"breaker_half_open_probes":  config.BreakerHalfOpenProbes,
+
+			"history_versions":  config.HistoryVersions,
+			"history_retention": int64(config.HistoryRetention.Seconds()),
+
+			"client_rate_limit":       config.ClientRateLimit,
+			"client_rate_limit_burst": config.ClientRateLimitBurst,
 		},
 	}, nil
 }
@@ -86,7 +221,7 @@ func (b *RollbarBackend) pathConfigWrite(ctx context.Context, req *logical.Reque
 		if !createOperation {
 			return nil, errors.New("config not found during update operation")
 		}
-		config = new(RollbarConfig)
+		config = &RollbarConfig{SchemaVersion: configSchemaVersion}
 	}
 
 	if accountAccessToken, ok := data.GetOk("account_access_token"); ok {
@@ -95,17 +230,108 @@ func (b *RollbarBackend) pathConfigWrite(ctx context.Context, req *logical.Reque
 		return nil, fmt.Errorf("missing Account Access Token in configuration")
 	}
 
-	entry, err := logical.StorageEntryJSON(configStoragePath, config)
-	if err != nil {
-		return nil, err
+	if secondary, ok := data.GetOk("secondary_account_access_token"); ok {
+		config.SecondaryAccountAccessToken = secondary.(string)
+	}
+
+	if apiURL, ok := data.GetOk("api_url"); ok {
+		config.APIURL = strings.TrimSuffix(apiURL.(string), "/")
+		if config.APIURL != "" {
+			if u, err := url.Parse(config.APIURL); err != nil || u.Scheme == "" || u.Host == "" {
+				return logical.ErrorResponse("api_url must be an absolute URL"), nil
+			}
+		}
+	}
+
+	if reconcileInterval, ok := data.GetOk("reconcile_interval"); ok {
+		config.ReconcileInterval = time.Duration(reconcileInterval.(int)) * time.Second
+	} else if createOperation {
+		config.ReconcileInterval = time.Duration(data.Get("reconcile_interval").(int)) * time.Second
+	}
+
+	if createTimeout, ok := data.GetOk("create_timeout"); ok {
+		config.CreateTimeout = time.Duration(createTimeout.(int)) * time.Second
+	}
+
+	if deleteTimeout, ok := data.GetOk("delete_timeout"); ok {
+		config.DeleteTimeout = time.Duration(deleteTimeout.(int)) * time.Second
+	}
+
+	if listTimeout, ok := data.GetOk("list_timeout"); ok {
+		config.ListTimeout = time.Duration(listTimeout.(int)) * time.Second
+	}
+
+	if verifyTimeout, ok := data.GetOk("verify_timeout"); ok {
+		config.VerifyTimeout = time.Duration(verifyTimeout.(int)) * time.Second
+	}
+
+	if threshold, ok := data.GetOk("breaker_failure_threshold"); ok {
+		if threshold.(int) == 0 {
+			return logical.ErrorResponse("breaker_failure_threshold cannot be 0, set a negative value to disable the circuit breaker"), nil
+		}
+		config.BreakerFailureThreshold = threshold.(int)
+	} else if createOperation {
+		config.BreakerFailureThreshold = data.Get("breaker_failure_threshold").(int)
+	}
+
+	if openDuration, ok := data.GetOk("breaker_open_duration"); ok {
+		config.BreakerOpenDuration = time.Duration(openDuration.(int)) * time.Second
+	} else if createOperation {
+		config.BreakerOpenDuration = time.Duration(data.Get("breaker_open_duration").(int)) * time.Second
+	}
+
+	if probes, ok := data.GetOk("breaker_half_open_probes"); ok {
+		config.BreakerHalfOpenProbes = probes.(int)
+	} else if createOperation {
+		config.BreakerHalfOpenProbes = data.Get("breaker_half_open_probes").(int)
 	}
 
-	if err := req.Storage.Put(ctx, entry); err != nil {
+	if historyVersions, ok := data.GetOk("history_versions"); ok {
+		config.HistoryVersions = historyVersions.(int)
+	} else if createOperation {
+		config.HistoryVersions = data.Get("history_versions").(int)
+	}
+
+	if retention, ok := data.GetOk("history_retention"); ok {
+		config.HistoryRetention = time.Duration(retention.(int)) * time.Second
+	} else if createOperation {
+		config.HistoryRetention = time.Duration(data.Get("history_retention").(int)) * time.Second
+	}
+
+	if rateLimit, ok := data.GetOk("client_rate_limit"); ok {
+		config.ClientRateLimit = rateLimit.(float64)
+	} else if createOperation {
+		config.ClientRateLimit = data.Get("client_rate_limit").(float64)
+	}
+
+	if burst, ok := data.GetOk("client_rate_limit_burst"); ok {
+		config.ClientRateLimitBurst = burst.(int)
+	} else if createOperation {
+		config.ClientRateLimitBurst = data.Get("client_rate_limit_burst").(int)
+	}
+
+	if config.ClientRateLimit < 0 || config.ClientRateLimitBurst < 0 {
+		return logical.ErrorResponse("client_rate_limit and client_rate_limit_burst cannot be negative"), nil
+	}
+
+	if config.HistoryVersions < 0 {
+		return logical.ErrorResponse("history_versions cannot be negative"), nil
+	}
+
+	if config.HistoryRetention < 0 {
+		return logical.ErrorResponse("history_retention cannot be negative"), nil
+	}
+
+	if err := setConfig(ctx, req.Storage, config); err != nil {
 		return nil, err
 	}
 
 	b.reset()
 
+	if err := b.recordVersion(ctx, req, versionsConfigKey, config); err != nil {
+		return nil, err
+	}
+
 	return nil, nil
 }
 
@@ -115,11 +341,55 @@ func (b *RollbarBackend) pathConfigDelete(ctx context.Context, req *logical.Requ
 
 	if err == nil {
 		b.reset()
+		err = b.recordVersion(ctx, req, versionsConfigKey, nil)
 	}
 
 	return nil, err
 }
 
+func (b *RollbarBackend) pathConfigPromote(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+
+	config, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return logical.ErrorResponse("backend is not configured"), nil
+	}
+
+	if config.SecondaryAccountAccessToken == "" {
+		return logical.ErrorResponse("no secondary account access token to promote"), nil
+	}
+
+	candidate := *config
+	candidate.AccountAccessToken = config.SecondaryAccountAccessToken
+	candidate.SecondaryAccountAccessToken = ""
+
+	client, err := NewClient(&candidate)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.Ping(ctx); err != nil {
+		return nil, rollbarCodedError(err, "error verifying secondary account access token")
+	}
+
+	config.AccountAccessToken, config.SecondaryAccountAccessToken = config.SecondaryAccountAccessToken, config.AccountAccessToken
+
+	if err := setConfig(ctx, req.Storage, config); err != nil {
+		return nil, err
+	}
+
+	b.reset()
+
+	if err := b.recordVersion(ctx, req, versionsConfigKey, config); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
 func (b *RollbarBackend) PathConfigExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
 
 	out, err := req.Storage.Get(ctx, configStoragePath)
@@ -130,19 +400,44 @@ func (b *RollbarBackend) PathConfigExistenceCheck(ctx context.Context, req *logi
 }
 
 func getConfig(ctx context.Context, s logical.Storage) (*RollbarConfig, error) {
+	config, _, err := readConfig(ctx, s)
+	return config, err
+}
+
+// readConfig gets the config upgraded to the current schema, along with
+// the schema version it was stored in
+func readConfig(ctx context.Context, s logical.Storage) (*RollbarConfig, int, error) {
 	entry, err := s.Get(ctx, configStoragePath)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	if entry == nil {
-		return nil, nil
+		return nil, 0, nil
 	}
 
 	config := new(RollbarConfig)
 	if err := entry.DecodeJSON(&config); err != nil {
-		return nil, fmt.Errorf("error reading root configuration: %w", err)
+		return nil, 0, fmt.Errorf("error reading root configuration: %w", err)
+	}
+
+	stored := config.SchemaVersion
+	if err := upgradeConfig(config); err != nil {
+		return nil, 0, err
+	}
+
+	return config, stored, nil
+}
+
+func setConfig(ctx context.Context, s logical.Storage, config *RollbarConfig) error {
+	if err := upgradeConfig(config); err != nil {
+		return err
+	}
+
+	entry, err := logical.StorageEntryJSON(configStoragePath, config)
+	if err != nil {
+		return err
 	}
 
-	return config, nil
+	return s.Put(ctx, entry)
 }
@@ -0,0 +1,346 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	pathCredsDef             = "creds/"
+	credsStoragePath         = "creds/"
+	pathCredsHelpSynopsis    = "Read the inventory record of an issued rollbar access token."
+	pathCredsHelpDescription = `
+	This path returns the inventory record kept by the backend for a token it has
+	issued. The record holds the role, project, scopes, token name, issuing
+	entity and timestamps. The token value itself is never stored here.
+
+	Vault assigns the lease ID after the backend has issued the token, so
+	lease_id is empty until the lease is first renewed.
+	`
+	pathCredsListHelpSynopsis    = "List the rollbar access tokens currently issued by the backend."
+	pathCredsListHelpDescription = `
+	Credentials will be listed by their inventory ID. The listing can be filtered
+	by role name, rollbar project ID, or the owner and metadata of the role the
+	credential was issued from.
+	`
+)
+
+// RollbarCredentialEntry is the inventory record of a token
+// issued by the backend. It never holds the token value. The lease ID
+// is only known once the lease has been renewed.
+type RollbarCredentialEntry struct {
+	ID                string            `json:"id"`
+	Type              string            `json:"type"`
+	Role              string            `json:"role"`
+	ProjectID         int               `json:"project_id"`
+	Scopes            []string          `json:"scope_list"`
+	LegacyScopes      string            `json:"scopes,omitempty"`
+	TokenName         string            `json:"token_name"`
+	LeaseID           string            `json:"lease_id"`
+	EntityID          string            `json:"entity_id"`
+	EntityDisplayName string            `json:"entity_display_name"`
+	CreatedAt         time.Time         `json:"created_at"`
+	ExpiresAt         time.Time         `json:"expires_at"`
+	Drift             string            `json:"drift,omitempty"`
+	Owner             string            `json:"owner,omitempty"`
+	Metadata          map[string]string `json:"metadata,omitempty"`
+	PendingClaim      bool              `json:"pending_claim,omitempty"`
+}
+
+func pathCreds(b *RollbarBackend) []*framework.Path {
+
+	return []*framework.Path{
+		{
+			Pattern: pathCredsDef + framework.GenericNameRegex("id"),
+			Fields: map[string]*framework.FieldSchema{
+				"id": {
+					Type:        framework.TypeString,
+					Description: "Required. Inventory ID of the issued credential",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathCredsRead,
+				},
+			},
+			HelpSynopsis:    pathCredsHelpSynopsis,
+			HelpDescription: pathCredsHelpDescription,
+		},
+		{
+			Pattern: pathCredsDef + "?$",
+			Fields: map[string]*framework.FieldSchema{
+				"role": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Optional. Only list credentials issued from this role",
+				},
+				"project_id": {
+					Type:        framework.TypeInt,
+					Description: "Optional. Only list credentials issued for this rollbar project",
+				},
+				"owner": {
+					Type:        framework.TypeString,
+					Description: "Optional. Only list credentials issued from roles with this owner",
+				},
+				"metadata": {
+					Type:        framework.TypeKVPairs,
+					Description: "Optional. Only list credentials issued from roles with all of these key=value metadata pairs",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathCredsList,
+				},
+			},
+			HelpSynopsis:    pathCredsListHelpSynopsis,
+			HelpDescription: pathCredsListHelpDescription,
+		},
+	}
+}
+
+// pathCredsList lists the issued credentials, optionally filtered
+func (b *RollbarBackend) pathCredsList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	role := d.Get("role").(string)
+	projectID := d.Get("project_id").(int)
+	owner := d.Get("owner").(string)
+	metadata := d.Get("metadata").(map[string]string)
+
+	creds, err := listCredentials(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	keyInfo := map[string]interface{}{}
+	for _, cred := range creds {
+		if role != "" && cred.Role != role {
+			continue
+		}
+		if projectID != 0 && cred.ProjectID != projectID {
+			continue
+		}
+		if owner != "" && cred.Owner != owner {
+			continue
+		}
+		if !matchesMetadata(cred.Metadata, metadata) {
+			continue
+		}
+		keys = append(keys, cred.ID)
+		keyInfo[cred.ID] = map[string]interface{}{
+			"role":       cred.Role,
+			"project_id": cred.ProjectID,
+			"token_name": cred.TokenName,
+			"expires_at": cred.ExpiresAt,
+		}
+	}
+
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
+
+// pathCredsRead returns a specific credential inventory record
+func (b *RollbarBackend) pathCredsRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	cred, err := getCredential(ctx, req.Storage, d.Get("id").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if cred == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: cred.toResponseData(),
+	}, nil
+}
+
+// recordCredential stores the inventory record of a newly issued secret,
+// stamping it with the requesting entity and the lease expiry, and records
+// the issuance in the history
+func (b *RollbarBackend) recordCredential(ctx context.Context, req *logical.Request, resp *logical.Response, cred *RollbarCredentialEntry) error {
+
+	ttl := resp.Secret.TTL
+	if ttl == 0 {
+		ttl = b.System().DefaultLeaseTTL()
+	}
+
+	now := time.Now().UTC()
+	cred.EntityID = req.EntityID
+	cred.EntityDisplayName = req.DisplayName
+	cred.CreatedAt = now
+	cred.ExpiresAt = now.Add(ttl)
+
+	if err := setCredential(ctx, req.Storage, cred); err != nil {
+		return err
+	}
+
+	b.recordEvent(ctx, req.Storage, credentialEvent(eventIssue, cred))
+
+	return nil
+}
+
+// getCredential gets a credential inventory record from the Vault storage API
+func getCredential(ctx context.Context, s logical.Storage, id string) (*RollbarCredentialEntry, error) {
+
+	if id == "" {
+		return nil, fmt.Errorf("missing credential ID")
+	}
+
+	entry, err := s.Get(ctx, credsStoragePath+id)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var cred RollbarCredentialEntry
+	if err := entry.DecodeJSON(&cred); err != nil {
+		return nil, err
+	}
+
+	if cred.Type == "" {
+		cred.Type = roleTypeProject
+	}
+	upgradeScopes(&cred.Scopes, &cred.LegacyScopes)
+
+	return &cred, nil
+}
+
+// setCredential sets a credential inventory record into the Vault storage
+// API and indexes its token as held by its role and by its entity
+func setCredential(ctx context.Context, s logical.Storage, cred *RollbarCredentialEntry) error {
+
+	entry, err := logical.StorageEntryJSON(credsStoragePath+cred.ID, cred)
+	if err != nil {
+		return err
+	}
+
+	if entry == nil {
+		return fmt.Errorf("failed to create storage entry for credential")
+	}
+
+	if err := s.Put(ctx, entry); err != nil {
+		return err
+	}
+
+	if cred.EntityID != "" {
+		if err := s.Put(ctx, &logical.StorageEntry{Key: entityTokenKey(cred.Role, cred.EntityID, cred.TokenName, cred.ID), Value: []byte{}}); err != nil {
+			return err
+		}
+	}
+
+	return putActiveToken(ctx, s, cred.Role, cred.TokenName, cred.ID)
+}
+
+// lockCredential serializes changes to a credential inventory record so an
+// update made from a stale read cannot bring back a deleted record. It
+// returns the unlock function.
+func (b *RollbarBackend) lockCredential(id string) func() {
+	lock := locksutil.LockForKey(b.credLocks, id)
+	lock.Lock()
+	return lock.Unlock
+}
+
+// updateCredential re-reads a credential inventory record under its lock,
+// applies update and stores the result. A record deleted in the meantime
+// is left deleted.
+func (b *RollbarBackend) updateCredential(ctx context.Context, s logical.Storage, id string, update func(cred *RollbarCredentialEntry)) error {
+
+	if id == "" {
+		return nil
+	}
+
+	unlock := b.lockCredential(id)
+	defer unlock()
+
+	cred, err := getCredential(ctx, s, id)
+	if err != nil || cred == nil {
+		return err
+	}
+
+	update(cred)
+
+	return setCredential(ctx, s, cred)
+}
+
+// deleteCredential removes a credential inventory record and its index
+// entries from the Vault storage API
+func (b *RollbarBackend) deleteCredential(ctx context.Context, s logical.Storage, id string) error {
+
+	if id == "" {
+		return nil
+	}
+
+	unlock := b.lockCredential(id)
+	defer unlock()
+
+	cred, err := getCredential(ctx, s, id)
+	if err != nil || cred == nil {
+		return err
+	}
+
+	if err := s.Delete(ctx, credsStoragePath+id); err != nil {
+		return err
+	}
+
+	if cred.EntityID != "" {
+		if err := s.Delete(ctx, entityTokenKey(cred.Role, cred.EntityID, cred.TokenName, cred.ID)); err != nil {
+			return err
+		}
+	}
+
+	return s.Delete(ctx, activeTokenKey(cred.Role, cred.TokenName, cred.ID))
+}
+
+// listCredentials returns every credential inventory record
+func listCredentials(ctx context.Context, s logical.Storage) ([]*RollbarCredentialEntry, error) {
+
+	ids, err := s.List(ctx, credsStoragePath)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := make([]*RollbarCredentialEntry, 0, len(ids))
+	for _, id := range ids {
+		cred, err := getCredential(ctx, s, id)
+		if err != nil {
+			return nil, fmt.Errorf("error reading credential %q: %w", id, err)
+		}
+		if cred == nil {
+			continue
+		}
+		creds = append(creds, cred)
+	}
+
+	return creds, nil
+}
+
+// toResponseData returns response data for a credential inventory record
+func (c *RollbarCredentialEntry) toResponseData() map[string]interface{} {
+
+	return map[string]interface{}{
+		"id":                  c.ID,
+		"type":                c.Type,
+		"role":                c.Role,
+		"project_id":          c.ProjectID,
+		"scopes":              c.Scopes,
+		"token_name":          c.TokenName,
+		"lease_id":            c.LeaseID,
+		"entity_id":           c.EntityID,
+		"entity_display_name": c.EntityDisplayName,
+		"created_at":          c.CreatedAt,
+		"expires_at":          c.ExpiresAt,
+		"drift":               c.Drift,
+		"owner":               c.Owner,
+		"metadata":            c.Metadata,
+		"pending_claim":       c.PendingClaim,
+	}
+}
@@ -0,0 +1,152 @@
+package plugin
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	pathHistoryDef             = "history/"
+	pathHistoryHelpSynopsis    = "List the issuance history of the backend."
+	pathHistoryHelpDescription = `
+	The backend records an event each time it issues, renews, revokes or rolls
+	back a token, and each time one of these operations fails. Events hold the
+	role, rollbar project, token name, credential ID, lease ID, the entity the
+	token was issued to, the outcome and the time. Issue events have no lease ID
+	as Vault assigns it afterwards; they share their credential ID with the
+	later events of the same token.
+
+	Events are listed oldest first and can be filtered by time range, event
+	type, role, project and entity. They are kept for history_retention, set on
+	the config.
+	`
+)
+
+func pathHistory(b *RollbarBackend) *framework.Path {
+
+	return &framework.Path{
+		Pattern: pathHistoryDef + "?$",
+		Fields: map[string]*framework.FieldSchema{
+			"start": {
+				Type:        framework.TypeTime,
+				Description: "Optional. Only list events at or after this time, as RFC3339 or seconds since the epoch",
+			},
+			"end": {
+				Type:        framework.TypeTime,
+				Description: "Optional. Only list events before this time, as RFC3339 or seconds since the epoch",
+			},
+			"type": {
+				Type:          framework.TypeString,
+				Description:   "Optional. Only list events of this type",
+				AllowedValues: []interface{}{eventIssue, eventRenew, eventRevoke, eventRollback, eventFailure},
+			},
+			"role": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "Optional. Only list events of tokens issued from this role",
+			},
+			"project_id": {
+				Type:        framework.TypeInt,
+				Description: "Optional. Only list events of tokens issued for this rollbar project",
+			},
+			"entity_id": {
+				Type:        framework.TypeString,
+				Description: "Optional. Only list events of tokens issued to this entity",
+			},
+			"after": {
+				Type:        framework.TypeString,
+				Description: "Optional. Only list events whose key sorts after this one",
+			},
+			"limit": {
+				Type:        framework.TypeInt,
+				Description: "Optional. Maximum number of events listed. If not set or set to 0, every matching event is listed.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.pathHistoryList,
+			},
+		},
+		HelpSynopsis:    pathHistoryHelpSynopsis,
+		HelpDescription: pathHistoryHelpDescription,
+	}
+}
+
+// pathHistoryList lists the history events matching the given filters
+func (b *RollbarBackend) pathHistoryList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	start := d.Get("start").(time.Time)
+	end := d.Get("end").(time.Time)
+	eventType := d.Get("type").(string)
+	role := d.Get("role").(string)
+	projectID := d.Get("project_id").(int)
+	entityID := d.Get("entity_id").(string)
+	after := d.Get("after").(string)
+	limit := d.Get("limit").(int)
+
+	if limit < 0 {
+		return logical.ErrorResponse("limit cannot be negative"), nil
+	}
+
+	if !start.IsZero() && !end.IsZero() && !end.After(start) {
+		return logical.ErrorResponse("end must be after start"), nil
+	}
+
+	entries, err := req.Storage.List(ctx, historyStoragePath)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(entries)
+
+	keys := []string{}
+	keyInfo := map[string]interface{}{}
+	for _, k := range entries {
+		if after != "" && k <= after {
+			continue
+		}
+		if limit > 0 && len(keys) == limit {
+			break
+		}
+
+		at, ok := historyKeyTime(k)
+		if !ok {
+			continue
+		}
+		if !start.IsZero() && at.Before(start) {
+			continue
+		}
+		// keys sort chronologically so the rest are later
+		if !end.IsZero() && !at.Before(end) {
+			break
+		}
+
+		e, err := getHistoryEvent(ctx, req.Storage, historyStoragePath+k)
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			continue
+		}
+
+		if eventType != "" && e.Type != eventType {
+			continue
+		}
+		if role != "" && e.Role != role {
+			continue
+		}
+		if projectID != 0 && e.ProjectID != projectID {
+			continue
+		}
+		if entityID != "" && e.EntityID != entityID {
+			continue
+		}
+
+		keys = append(keys, k)
+		keyInfo[k] = e.toResponseData()
+	}
+
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
@@ -2,7 +2,9 @@ package plugin
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/sdk/framework"
@@ -16,9 +18,51 @@ const (
 	`
 	pathProjectAccessTokenDesc = `
 	This path generates a rollbar access token based on a particular role.
+
+	Alongside the token the response carries the project ID and name, the scopes,
+	the token name, its rate limit and the issue and expiry times. None of these
+	are secret; add them to the mount's audit_non_hmac_response_keys to have them
+	logged in clear while the token itself stays hashed.
+
+	On roles with reuse_for_entity set, an entity requesting the role again gets
+	the token it was handed earlier, under a new lease, while more than
+	reuse_min_remaining of that token's first lease remains. The token is only
+	deleted in rollbar once every lease handing it out has been revoked.
+
+	On roles with activate_on_claim set, the token is created disabled and the
+	response carries a one-time claim_handle instead of the token. Writing the
+	handle to projectaccesstoken/claim within the role's claim_window enables the
+	token and returns it. Tokens not claimed in time are deleted.
+	`
+	pathProjectAccessTokenClaimHelpSyn  = "Claim a project access token issued disabled."
+	pathProjectAccessTokenClaimHelpDesc = `
+	This path takes the one-time claim_handle returned when a role with
+	activate_on_claim issues a token, enables the token in rollbar and returns
+	it. A handle can only be claimed once and only within the role's
+	claim_window. The lease of the token is the one returned at issuance.
 	`
 )
 
+func pathProjectAccessTokenClaim(b *RollbarBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: projectAccessTokenPath + "claim$",
+		Fields: map[string]*framework.FieldSchema{
+			"handle": {
+				Type:        framework.TypeString,
+				Description: "Required. Claim handle returned at issuance",
+				Required:    true,
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathProjectAccessTokenClaim,
+			},
+		},
+		HelpSynopsis:    pathProjectAccessTokenClaimHelpSyn,
+		HelpDescription: pathProjectAccessTokenClaimHelpDesc,
+	}
+}
+
 func pathProjectAccessToken(b *RollbarBackend) *framework.Path {
 	return &framework.Path{
 		Pattern: projectAccessTokenPath + framework.GenericNameRegex("name"),
@@ -30,8 +74,8 @@ func pathProjectAccessToken(b *RollbarBackend) *framework.Path {
 			},
 		},
 		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.ReadOperation:   b.pathProjectAccessTokenRead,
-			logical.UpdateOperation: b.pathProjectAccessTokenRead,
+			logical.ReadOperation:   b.withIssueHistory(b.pathProjectAccessTokenRead),
+			logical.UpdateOperation: b.withIssueHistory(b.pathProjectAccessTokenRead),
 		},
 		HelpSynopsis:    pathProjectAccessTokenHelpSyn,
 		HelpDescription: pathProjectAccessTokenDesc,
@@ -47,28 +91,116 @@ func (b *RollbarBackend) pathProjectAccessTokenRead(ctx context.Context, req *lo
 		return nil, fmt.Errorf("error retrieving role: %w", err)
 	}
 
+	if roleEntry == nil {
+		return logical.ErrorResponse("role %q not found", roleName), nil
+	}
+
+	if roleEntry.RoleType != roleTypeProject {
+		return logical.ErrorResponse("role %q issues %s access tokens", roleName, roleEntry.RoleType), nil
+	}
+
+	if resp, err := checkProjectBlocked(ctx, req.Storage, roleEntry.ProjectID); err != nil || resp != nil {
+		return resp, err
+	}
+
 	client, err := b.getClient(ctx, req.Storage)
 	if err != nil {
 		return nil, fmt.Errorf("error getting client: %w", err)
 	}
 
-	uuid, err := uuid.GenerateUUID()
-	if err != nil {
-		return nil, fmt.Errorf("error generating UUID for project access token name: %w", err)
+	var (
+		credID   string
+		patName  string
+		token    *rollbarProjectAccessToken
+		reused   *reusableToken
+		reuseKey string
+		pooled   *pooledToken
+
+		reservation *tokenReservation
+	)
+
+	reuse := roleEntry.ReuseForEntity && req.EntityID != ""
+	if reuse {
+		unlock := b.lockReuse(roleName, req.EntityID)
+		defer unlock()
+
+		reused, reuseKey, err = b.findReusableToken(ctx, req.Storage, roleEntry, req.EntityID)
+		if err != nil {
+			b.Logger().Warn("error looking up reusable token, issuing a new one", "role", roleName, "error", err)
+			reused = nil
+		}
 	}
-	patName := roleName + "-" + uuid
 
-	pat, err := createProjectAccessToken(ctx, client, roleEntry.ProjectAccessTokenScopes, roleEntry.ProjectID, patName)
-	if err != nil || pat == nil || len(*pat) == 0 {
-		return nil, fmt.Errorf("error creating project access token: %w", err)
+	if reused == nil && req.EntityID != "" {
+		unlockQuota := b.lockQuota(roleName, req.EntityID)
+		defer unlockQuota()
+
+		if resp, err := b.checkEntityQuota(ctx, req.Storage, roleEntry, req.EntityID, 1); err != nil || resp != nil {
+			return resp, err
+		}
+	}
+
+	if reused == nil {
+		pooled, err = b.takePooledToken(ctx, req.Storage, roleEntry)
+		if err != nil {
+			b.Logger().Warn("error taking token from pool, creating one", "role", roleName, "error", err)
+		}
 	}
 
+	isReused := reused != nil
+	if isReused {
+		credID, err = uuid.GenerateUUID()
+		if err != nil {
+			return nil, fmt.Errorf("error generating UUID for credential: %w", err)
+		}
+		patName = reused.TokenName
+		token = &reused.Token
+	} else if pooled != nil {
+		credID = pooled.ID
+		patName = pooled.TokenName
+		token = &pooled.Token
+	} else {
+		reservations, resp, err := b.reserveTokens(ctx, req.Storage, roleEntry, roleName+"-", 1, false)
+		if err != nil || resp != nil {
+			return resp, err
+		}
+		reservation = reservations[0]
+		credID = reservation.CredentialID
+		patName = reservation.TokenName
+
+		if roleEntry.ActivateOnClaim {
+			token, err = client.CreateProjectAccessTokenWithStatus(ctx, roleEntry.ProjectAccessTokenScopes, roleEntry.ProjectID, patName, tokenStatusDisabled)
+		} else {
+			token, err = createProjectAccessToken(ctx, client, roleEntry.ProjectAccessTokenScopes, roleEntry.ProjectID, patName)
+		}
+		if err != nil {
+			b.releaseFailedCreate(ctx, req.Storage, err, reservation)
+			return nil, rollbarCodedError(err, "error creating project access token")
+		}
+		if token == nil || len(token.AccessToken) == 0 {
+			b.releaseUnconfirmed(ctx, req.Storage, reservation)
+			return nil, errors.New("error creating project access token: rollbar returned no token")
+		}
+	}
+	pat := token.AccessToken
+
 	resp := b.Secret(rollbarProjectAccessTokenType).Response(map[string]interface{}{
-		"project_access_token": *pat,
+		"project_access_token": pat,
 	}, map[string]interface{}{
-		"project_access_token": *pat,
+		"project_access_token": pat,
 		"role":                 roleEntry.Name,
+		"project_id":           roleEntry.ProjectID,
+		"credential_id":        credID,
 	})
+	roleEntry.annotate(resp)
+
+	if reuse {
+		if !isReused {
+			reuseKey = reuseEntityPath(roleName, req.EntityID) + credID
+		}
+		resp.Secret.InternalData["reuse_key"] = reuseKey
+		resp.Secret.InternalData["entity_id"] = req.EntityID
+	}
 
 	if roleEntry.TTL > 0 {
 		resp.Secret.TTL = roleEntry.TTL
@@ -78,5 +210,150 @@ func (b *RollbarBackend) pathProjectAccessTokenRead(ctx context.Context, req *lo
 		resp.Secret.MaxTTL = roleEntry.MaxTTL
 	}
 
+	var (
+		claimHandle     string
+		claimStorageKey string
+		claim           *pendingClaim
+	)
+	if roleEntry.ActivateOnClaim {
+		claim = &pendingClaim{
+			CredentialID: credID,
+			Role:         roleEntry.Name,
+			ProjectID:    roleEntry.ProjectID,
+			TokenName:    patName,
+			Token:        *token,
+			ExpiresAt:    time.Now().UTC().Add(roleEntry.claimWindow()),
+		}
+		claimHandle, claimStorageKey, err = createClaim(ctx, req.Storage, claim)
+		if err != nil {
+			if delErr := deleteProjectAccessToken(ctx, client, roleEntry.ProjectID, pat); delErr != nil {
+				b.Logger().Error("error deleting unclaimable project access token", "name", patName, "error", delErr)
+			} else {
+				b.releaseReservations(ctx, req.Storage, reservation)
+			}
+			return nil, fmt.Errorf("error storing claim: %w", err)
+		}
+		resp.Secret.InternalData["claim_key"] = claimStorageKey
+		// the token is only handed out once claimed
+		delete(resp.Data, "project_access_token")
+	}
+
+	cred := &RollbarCredentialEntry{
+		ID:           credID,
+		Type:         roleTypeProject,
+		Role:         roleEntry.Name,
+		ProjectID:    roleEntry.ProjectID,
+		Scopes:       roleEntry.scopes(),
+		TokenName:    patName,
+		Owner:        roleEntry.Owner,
+		Metadata:     roleEntry.Metadata,
+		PendingClaim: roleEntry.ActivateOnClaim,
+	}
+	if err := b.recordCredential(ctx, req, resp, cred); err != nil {
+		if claimStorageKey != "" {
+			if delErr := req.Storage.Delete(ctx, claimStorageKey); delErr != nil {
+				b.Logger().Error("error removing claim of unrecorded token", "name", patName, "error", delErr)
+			}
+		}
+		// a reused token is still held by earlier leases
+		if isReused {
+			return nil, fmt.Errorf("error recording issued credential: %w", err)
+		}
+		b.Logger().Error("error recording issued credential, deleting project access token", "name", patName, "error", err)
+		if delErr := deleteProjectAccessToken(ctx, client, roleEntry.ProjectID, pat); delErr != nil {
+			b.Logger().Error("error deleting unrecorded project access token", "name", patName, "error", delErr)
+		} else {
+			b.releaseReservations(ctx, req.Storage, reservation)
+		}
+		return nil, fmt.Errorf("error recording issued credential: %w", err)
+	}
+	b.commitReservations(ctx, req.Storage, reservation)
+
+	if reuse {
+		if !isReused {
+			reused = &reusableToken{
+				TokenName: patName,
+				ProjectID: roleEntry.ProjectID,
+				Scopes:    roleEntry.scopes(),
+				Token:     *token,
+				IssuedAt:  cred.CreatedAt,
+				ExpiresAt: cred.ExpiresAt,
+			}
+			if err := holdReusableToken(ctx, req.Storage, reuseKey, reused, credID); err != nil {
+				b.Logger().Warn("error recording reusable token, it will not be reused", "name", patName, "error", err)
+			}
+		} else if err := holdReusableToken(ctx, req.Storage, reuseKey, reused, credID); err != nil {
+			if delErr := b.deleteCredential(ctx, req.Storage, credID); delErr != nil {
+				b.Logger().Error("error removing credential record", "credential_id", credID, "error", delErr)
+			}
+			return nil, fmt.Errorf("error recording reused token holder: %w", err)
+		}
+	}
+
+	if !isReused {
+		if err := recordEntityIssuance(ctx, req.Storage, roleEntry, req.EntityID, 1); err != nil {
+			b.Logger().Warn("error recording entity issuance", "role", roleName, "error", err)
+		}
+	}
+
+	projectName := ""
+	project, err := b.getProject(ctx, client, roleEntry.ProjectID)
+	if err != nil {
+		b.Logger().Warn("error looking up rollbar project", "project_id", roleEntry.ProjectID, "error", err)
+		resp.AddWarning("unable to look up the rollbar project name")
+	} else {
+		projectName = project.Name
+	}
+
+	scopes := token.Scopes
+	if len(scopes) == 0 {
+		scopes = roleEntry.scopes()
+	}
+
+	resp.Data["project_id"] = roleEntry.ProjectID
+	resp.Data["project_name"] = projectName
+	resp.Data["scopes"] = scopes
+	resp.Data["token_name"] = patName
+	resp.Data["rate_limit"] = map[string]interface{}{
+		"window_size":  token.RateLimitWindowSize,
+		"window_count": token.RateLimitWindowCount,
+	}
+	resp.Data["issued_at"] = cred.CreatedAt
+	resp.Data["expires_at"] = cred.ExpiresAt
+	resp.Data["reused"] = isReused
+	if claim != nil {
+		resp.Data["claim_handle"] = claimHandle
+		resp.Data["claim_expires_at"] = claim.ExpiresAt
+	}
+
 	return resp, nil
 }
+
+// pathProjectAccessTokenClaim enables and returns a token issued disabled
+func (b *RollbarBackend) pathProjectAccessTokenClaim(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	handle := d.Get("handle").(string)
+	if handle == "" {
+		return logical.ErrorResponse("missing claim handle"), nil
+	}
+
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
+	}
+
+	claim, resp, err := b.claimToken(ctx, req.Storage, client, handle)
+	if err != nil || resp != nil {
+		return resp, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"project_access_token": claim.Token.AccessToken,
+			"project_id":           claim.ProjectID,
+			"token_name":           claim.TokenName,
+			"role":                 claim.Role,
+			"credential_id":        claim.CredentialID,
+		},
+	}, nil
+}
@@ -0,0 +1,324 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	maxBatchSizeLimit                  = 1000
+	batchConcurrency                   = 8
+	pathProjectAccessTokenBatchHelpSyn = `
+	Generate several rollbar project access tokens from a role in one request.
+	`
+	pathProjectAccessTokenBatchDesc = `
+	This path generates count rollbar project access tokens based on a role whose
+	max_batch_size allows it. Tokens are created concurrently, subject to the
+	backend's client rate limit. If any of them cannot be created, the ones
+	already created are deleted and the request fails.
+
+	Vault attaches one lease to a response, so the tokens of a batch share a
+	lease: renewing or revoking it renews or revokes all of them, and a single
+	token of the batch cannot be revoked on its own. Each token still has its own
+	record in the credential inventory. Consumers that need to revoke their token
+	independently should each read projectaccesstoken/<role> instead.
+	`
+)
+
+// issuedToken is a project access token created during a batch
+type issuedToken struct {
+	id    string
+	name  string
+	token *rollbarProjectAccessToken
+}
+
+func pathProjectAccessTokenBatch(b *RollbarBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: projectAccessTokenPath + framework.GenericNameRegex("name") + "/batch$",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "Name of the role",
+				Required:    true,
+			},
+			"count": {
+				Type:        framework.TypeInt,
+				Description: "Required. Number of project access tokens to generate, up to the role's max_batch_size",
+				Required:    true,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.withIssueHistory(b.pathProjectAccessTokenBatchWrite),
+		},
+		HelpSynopsis:    pathProjectAccessTokenBatchHelpSyn,
+		HelpDescription: pathProjectAccessTokenBatchDesc,
+	}
+}
+
+func (b *RollbarBackend) pathProjectAccessTokenBatchWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	roleName := d.Get("name").(string)
+	count := d.Get("count").(int)
+
+	roleEntry, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving role: %w", err)
+	}
+
+	if roleEntry == nil {
+		return logical.ErrorResponse("role %q not found", roleName), nil
+	}
+
+	if roleEntry.RoleType != roleTypeProject {
+		return logical.ErrorResponse("role %q issues %s access tokens", roleName, roleEntry.RoleType), nil
+	}
+
+	if resp, err := checkProjectBlocked(ctx, req.Storage, roleEntry.ProjectID); err != nil || resp != nil {
+		return resp, err
+	}
+
+	if roleEntry.MaxBatchSize == 0 {
+		return logical.ErrorResponse("role %q does not allow batch issuance", roleName), nil
+	}
+
+	if count < 1 || count > roleEntry.MaxBatchSize {
+		return logical.ErrorResponse("count must be between 1 and %d for role %q", roleEntry.MaxBatchSize, roleName), nil
+	}
+
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
+	}
+
+	if req.EntityID != "" {
+		unlockQuota := b.lockQuota(roleName, req.EntityID)
+		defer unlockQuota()
+
+		if resp, err := b.checkEntityQuota(ctx, req.Storage, roleEntry, req.EntityID, count); err != nil || resp != nil {
+			return resp, err
+		}
+	}
+
+	prefix, err := batchTokenPrefix(roleEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	reservations, resp, err := b.reserveTokens(ctx, req.Storage, roleEntry, prefix, count, false)
+	if err != nil || resp != nil {
+		return resp, err
+	}
+
+	issued, err := b.createProjectAccessTokenBatch(ctx, req, client, roleEntry, prefix, reservations)
+	if err != nil {
+		return nil, rollbarCodedError(err, "error creating project access tokens")
+	}
+
+	tokens := make([]batchToken, 0, len(issued))
+	data := make([]map[string]interface{}, 0, len(issued))
+	for _, t := range issued {
+		tokens = append(tokens, batchToken{
+			CredentialID:       t.id,
+			ProjectAccessToken: t.token.AccessToken,
+		})
+		data = append(data, map[string]interface{}{
+			"project_access_token": t.token.AccessToken,
+			"token_name":           t.name,
+			"credential_id":        t.id,
+		})
+	}
+
+	resp = b.Secret(rollbarProjectAccessTokenBatchType).Response(map[string]interface{}{
+		"project_access_tokens": data,
+		"project_id":            roleEntry.ProjectID,
+		"scopes":                roleEntry.scopes(),
+	}, map[string]interface{}{
+		"tokens":     tokens,
+		"role":       roleEntry.Name,
+		"project_id": roleEntry.ProjectID,
+	})
+	roleEntry.annotate(resp)
+
+	if roleEntry.TTL > 0 {
+		resp.Secret.TTL = roleEntry.TTL
+	}
+
+	if roleEntry.MaxTTL > 0 {
+		resp.Secret.MaxTTL = roleEntry.MaxTTL
+	}
+
+	for i, t := range issued {
+		cred := &RollbarCredentialEntry{
+			ID:        t.id,
+			Type:      roleTypeProject,
+			Role:      roleEntry.Name,
+			ProjectID: roleEntry.ProjectID,
+			Scopes:    roleEntry.scopes(),
+			TokenName: t.name,
+			Owner:     roleEntry.Owner,
+			Metadata:  roleEntry.Metadata,
+		}
+		if err := b.recordCredential(ctx, req, resp, cred); err != nil {
+			b.Logger().Error("error recording batch credential, rolling back batch", "role", roleName, "error", err)
+			for _, recorded := range issued[:i] {
+				if delErr := b.deleteCredential(ctx, req.Storage, recorded.id); delErr != nil {
+					b.Logger().Error("error removing credential record during batch rollback", "credential_id", recorded.id, "error", delErr)
+				}
+			}
+			b.rollbackProjectAccessTokens(ctx, req, client, roleEntry, prefix, issued, reservations)
+			return nil, fmt.Errorf("error recording issued credential: %w", err)
+		}
+	}
+	b.commitReservations(ctx, req.Storage, reservations...)
+
+	if err := recordEntityIssuance(ctx, req.Storage, roleEntry, req.EntityID, count); err != nil {
+		b.Logger().Warn("error recording entity issuance", "role", roleName, "error", err)
+	}
+
+	return resp, nil
+}
+
+// batchTokenPrefix returns the name prefix shared by the tokens of a new
+// batch, so the tokens of a failed batch can be found in rollbar
+func batchTokenPrefix(roleEntry *RollbarRoleEntry) (string, error) {
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return "", fmt.Errorf("error generating batch ID: %w", err)
+	}
+
+	return roleEntry.Name + "-" + id[:8] + "-", nil
+}
+
+// createProjectAccessTokenBatch creates a project access token for each
+// reservation concurrently. If any creation fails the tokens already
+// created are deleted and the first error is returned.
+func (b *RollbarBackend) createProjectAccessTokenBatch(ctx context.Context, req *logical.Request, client *rollbarClient, roleEntry *RollbarRoleEntry, prefix string, reservations []*tokenReservation) ([]issuedToken, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		issued   = make([]issuedToken, 0, len(reservations))
+		sem      = make(chan struct{}, batchConcurrency)
+	)
+
+	for _, r := range reservations {
+		wg.Add(1)
+		go func(r *tokenReservation) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			token, err := createProjectAccessToken(ctx, client, roleEntry.ProjectAccessTokenScopes, roleEntry.ProjectID, r.TokenName)
+			if err == nil && (token == nil || token.AccessToken == "") {
+				err = errors.New("rollbar returned no token")
+			}
+			if err == nil {
+				mu.Lock()
+				issued = append(issued, issuedToken{id: r.CredentialID, name: r.TokenName, token: token})
+				mu.Unlock()
+				return
+			}
+
+			mu.Lock()
+			if firstErr == nil {
+				firstErr = err
+				cancel()
+			}
+			mu.Unlock()
+		}(r)
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		// the request context may be canceled already, roll back regardless
+		b.rollbackProjectAccessTokens(context.Background(), req, client, roleEntry, prefix, issued, reservations)
+		return nil, firstErr
+	}
+
+	return issued, nil
+}
+
+// rollbackProjectAccessTokens deletes tokens created by a batch that failed
+// and records their rollback in the history. A create canceled after the
+// first failure may still have succeeded in rollbar, so the project's tokens
+// are then listed and those named with the batch prefix are deleted too.
+// The reservations of tokens known to be gone are released, the others are
+// left to the WAL rollback.
+func (b *RollbarBackend) rollbackProjectAccessTokens(ctx context.Context, req *logical.Request, client *rollbarClient, roleEntry *RollbarRoleEntry, prefix string, issued []issuedToken, reservations []*tokenReservation) {
+	attempted := map[string]bool{}
+	left := map[string]bool{}
+	for _, t := range issued {
+		attempted[t.name] = true
+		if !b.rollbackProjectAccessToken(ctx, req, client, roleEntry, t.id, t.name, t.token.AccessToken) {
+			left[t.name] = true
+		}
+	}
+
+	tokens, err := client.ListProjectAccessTokens(ctx, roleEntry.ProjectID)
+	if err != nil {
+		b.Logger().Error("error listing project access tokens during batch rollback, stray tokens may remain", "prefix", prefix, "error", err)
+		for _, r := range reservations {
+			if !attempted[r.TokenName] || left[r.TokenName] {
+				continue
+			}
+			b.releaseReservations(ctx, req.Storage, r)
+		}
+		return
+	}
+
+	for _, token := range tokens {
+		if !strings.HasPrefix(token.Name, prefix) || attempted[token.Name] {
+			continue
+		}
+		b.Logger().Warn("deleting stray project access token of failed batch", "name", token.Name)
+		if !b.rollbackProjectAccessToken(ctx, req, client, roleEntry, strings.TrimPrefix(token.Name, prefix), token.Name, token.AccessToken) {
+			left[token.Name] = true
+		}
+	}
+
+	for _, r := range reservations {
+		if !left[r.TokenName] {
+			b.releaseReservations(ctx, req.Storage, r)
+		}
+	}
+}
+
+// rollbackProjectAccessToken deletes a token of a failed batch and records
+// its rollback in the history. It reports whether the token is gone.
+func (b *RollbarBackend) rollbackProjectAccessToken(ctx context.Context, req *logical.Request, client *rollbarClient, roleEntry *RollbarRoleEntry, id, name, pat string) bool {
+	e := &historyEvent{
+		Type:              eventRollback,
+		Outcome:           outcomeSuccess,
+		Role:              roleEntry.Name,
+		ProjectID:         roleEntry.ProjectID,
+		TokenName:         name,
+		CredentialID:      id,
+		EntityID:          req.EntityID,
+		EntityDisplayName: req.DisplayName,
+	}
+
+	err := deleteProjectAccessToken(ctx, client, roleEntry.ProjectID, pat)
+	if err != nil && !errors.Is(err, errRollbarNotFound) {
+		b.Logger().Error("error deleting project access token during batch rollback", "name", name, "error", err)
+		e.failed(err)
+	}
+
+	b.recordEvent(ctx, req.Storage, e)
+	return e.Outcome == outcomeSuccess
+}
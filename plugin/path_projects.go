@@ -0,0 +1,504 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	pathProjectsDef                   = "projects/"
+	pathProjectsImportHelpSynopsis    = "Propose and create roles from a rollbar project's existing access tokens."
+	pathProjectsImportHelpDescription = `
+	Writing to projects/<id>/import-roles lists the project's enabled access
+	tokens in rollbar, skipping those issued by this backend or without scopes,
+	and groups them by scope set and rate limit. Each group becomes a proposed role named after the
+	prefix, its scopes and, when set, its rate limit.
+
+	By default this is a dry run that only returns the proposed role entries.
+	Write with dry_run=false to create them. Roles that already exist are never
+	overwritten and are reported as skipped. Proposed roles get a ttl of 1h and
+	a max_ttl of 2h, and the same validation as roles written to roles/<name>;
+	groups whose role would be invalid are reported as invalid with the reason.
+	Roles cannot set a rate limit on the tokens they issue, so a group's rate
+	limit is reported for reference only.
+	`
+	pathProjectsRevokeAllHelpSynopsis    = "Revoke every token the backend issued for a rollbar project."
+	pathProjectsRevokeAllHelpDescription = `
+	Writing to projects/<id>/revoke-all deletes in rollbar every token the
+	backend issued for the project, across all roles pointing at it, and removes
+	their inventory records. Pooled tokens and pending claims for the project are
+	deleted as well. The report lists the credentials revoked, those that could
+	not be under failed_credentials, and cleanup steps that failed under errors.
+
+	This does not revoke the Vault leases of the deleted tokens: a backend cannot
+	revoke leases. They stay listed in sys/leases until they expire, and revoking
+	them then succeeds without contacting rollbar. The report lists their known
+	lease IDs and the prefixes they were issued under, to remove them with vault
+	lease revoke -prefix.
+
+	With block_issuance=true, issuance for the project stops before anything is
+	revoked and stays blocked until projects/<id>/block is deleted. Restrict
+	this path to operators in your policies.
+	`
+	pathProjectsBlockHelpSynopsis    = "Read or clear the issuance block of a rollbar project."
+	pathProjectsBlockHelpDescription = `
+	Reading projects/<id>/block returns who blocked issuance for the project and
+	when. Deleting it lets roles issue tokens for the project again.
+	`
+)
+
+// roleProposal is a role proposed from a group of existing rollbar tokens
+type roleProposal struct {
+	Role       *RollbarRoleEntry
+	Tokens     []string
+	WindowSize int
+	Count      int
+}
+
+func pathProjects(b *RollbarBackend) []*framework.Path {
+
+	return []*framework.Path{
+		{
+			Pattern: pathProjectsDef + framework.GenericNameRegex("project_id") + "/import-roles",
+			Fields: map[string]*framework.FieldSchema{
+				"project_id": {
+					Type:        framework.TypeInt,
+					Description: "Required. ID of the rollbar project",
+					Required:    true,
+				},
+				"name_prefix": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Optional. Prefix of the proposed role names. Defaults to project-<id>.",
+				},
+				"dry_run": {
+					Type:        framework.TypeBool,
+					Description: "Optional. Only return the proposed roles without creating them. Defaults to true.",
+					Default:     true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathProjectsImportRoles,
+				},
+			},
+			HelpSynopsis:    pathProjectsImportHelpSynopsis,
+			HelpDescription: pathProjectsImportHelpDescription,
+		},
+		{
+			Pattern: pathProjectsDef + framework.GenericNameRegex("project_id") + "/revoke-all",
+			Fields: map[string]*framework.FieldSchema{
+				"project_id": {
+					Type:        framework.TypeInt,
+					Description: "Required. ID of the rollbar project",
+					Required:    true,
+				},
+				"block_issuance": {
+					Type:        framework.TypeBool,
+					Description: "Optional. Block issuance for the project until projects/<id>/block is deleted.",
+				},
+				"reason": {
+					Type:        framework.TypeString,
+					Description: "Optional. Reason recorded with the issuance block",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathProjectsRevokeAll,
+				},
+			},
+			HelpSynopsis:    pathProjectsRevokeAllHelpSynopsis,
+			HelpDescription: pathProjectsRevokeAllHelpDescription,
+		},
+		{
+			Pattern: pathProjectsDef + framework.GenericNameRegex("project_id") + "/block",
+			Fields: map[string]*framework.FieldSchema{
+				"project_id": {
+					Type:        framework.TypeInt,
+					Description: "Required. ID of the rollbar project",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathProjectsBlockRead,
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathProjectsBlockDelete,
+				},
+			},
+			HelpSynopsis:    pathProjectsBlockHelpSynopsis,
+			HelpDescription: pathProjectsBlockHelpDescription,
+		},
+	}
+}
+
+// pathProjectsImportRoles proposes, and optionally creates, roles matching
+// the existing access tokens of a rollbar project
+func (b *RollbarBackend) pathProjectsImportRoles(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	b.syncLock.RLock()
+	defer b.syncLock.RUnlock()
+
+	projectID := d.Get("project_id").(int)
+	if projectID <= 0 {
+		return logical.ErrorResponse("project_id must be a positive integer"), nil
+	}
+
+	prefix := d.Get("name_prefix").(string)
+	if prefix == "" {
+		prefix = fmt.Sprintf("project-%d", projectID)
+	}
+	if !roleNameRegex.MatchString(prefix) || reservedRoleName(prefix) {
+		return logical.ErrorResponse("invalid name_prefix %q", prefix), nil
+	}
+
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
+	}
+
+	tokens, err := client.ListProjectAccessTokens(ctx, projectID)
+	if err != nil {
+		return nil, rollbarCodedError(err, "error listing project access tokens")
+	}
+
+	proposals, invalid, err := b.proposeRoles(ctx, req.Storage, projectID, prefix, tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	dryRun := d.Get("dry_run").(bool)
+	proposed := make([]map[string]interface{}, 0, len(proposals))
+	created := []string{}
+	skipped := []string{}
+	for _, p := range proposals {
+		proposed = append(proposed, map[string]interface{}{
+			"role":   p.Role,
+			"tokens": p.Tokens,
+			"rate_limit": map[string]interface{}{
+				"window_size":  p.WindowSize,
+				"window_count": p.Count,
+			},
+		})
+
+		existing, err := b.getRole(ctx, req.Storage, p.Role.Name)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			skipped = append(skipped, p.Role.Name)
+			continue
+		}
+
+		if dryRun {
+			continue
+		}
+
+		if err := setRole(ctx, req.Storage, p.Role.Name, p.Role); err != nil {
+			return nil, err
+		}
+		if err := b.recordVersion(ctx, req, versionsRoleKey(p.Role.Name), p.Role); err != nil {
+			return nil, err
+		}
+		created = append(created, p.Role.Name)
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"project_id": projectID,
+			"dry_run":    dryRun,
+			"proposed":   proposed,
+			"created":    created,
+			"skipped":    skipped,
+			"invalid":    invalid,
+		},
+	}
+	if len(proposals) == 0 && len(invalid) == 0 {
+		resp.AddWarning("project has no enabled access tokens outside of those issued by this backend")
+	}
+
+	return resp, nil
+}
+
+// proposeRoles groups the enabled tokens of a project that were not issued
+// by the backend by scope set and rate limit, and proposes a role per group.
+// Proposed roles are built like roles written to roles/<name>; groups whose
+// role would be invalid are returned with the reason instead.
+func (b *RollbarBackend) proposeRoles(ctx context.Context, s logical.Storage, projectID int, prefix string, tokens []rollbarProjectAccessToken) ([]*roleProposal, map[string]string, error) {
+	creds, err := listCredentials(ctx, s)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	issued := map[string]bool{}
+	for _, cred := range creds {
+		issued[cred.TokenName] = true
+	}
+
+	groups := map[string]*roleProposal{}
+	invalid := map[string]string{}
+	for _, token := range tokens {
+		if token.Status != "enabled" || issued[token.Name] || len(token.Scopes) == 0 {
+			continue
+		}
+
+		scopes := append([]string(nil), token.Scopes...)
+		sort.Strings(scopes)
+
+		name := prefix + "-" + strings.Join(scopes, "-")
+		if token.RateLimitWindowCount > 0 {
+			name = fmt.Sprintf("%s-%dper%ds", name, token.RateLimitWindowCount, token.RateLimitWindowSize)
+		}
+
+		if _, ok := invalid[name]; ok {
+			continue
+		}
+
+		group, ok := groups[name]
+		if !ok {
+			roleEntry, err := newRoleEntry(name, map[string]interface{}{
+				"role_type":                   roleTypeProject,
+				"project_id":                  projectID,
+				"project_access_token_scopes": scopes,
+				"ttl":                         int(defaultTTL.Seconds()),
+				"max_ttl":                     int(defaultMaxTTL.Seconds()),
+			})
+			if err != nil {
+				invalid[name] = err.Error()
+				continue
+			}
+
+			group = &roleProposal{
+				Role:       roleEntry,
+				WindowSize: token.RateLimitWindowSize,
+				Count:      token.RateLimitWindowCount,
+			}
+			groups[name] = group
+		}
+		group.Tokens = append(group.Tokens, token.Name)
+	}
+
+	proposals := make([]*roleProposal, 0, len(groups))
+	for _, group := range groups {
+		sort.Strings(group.Tokens)
+		proposals = append(proposals, group)
+	}
+	sort.Slice(proposals, func(i, j int) bool {
+		return proposals[i].Role.Name < proposals[j].Role.Name
+	})
+
+	return proposals, invalid, nil
+}
+
+// pathProjectsRevokeAll revokes every token issued for a rollbar project
+// and optionally blocks further issuance for it
+func (b *RollbarBackend) pathProjectsRevokeAll(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	projectID := d.Get("project_id").(int)
+	if projectID <= 0 {
+		return logical.ErrorResponse("project_id must be a positive integer"), nil
+	}
+
+	blocked := d.Get("block_issuance").(bool)
+	if blocked {
+		block := &projectBlock{
+			ProjectID:         projectID,
+			Reason:            d.Get("reason").(string),
+			EntityID:          req.EntityID,
+			EntityDisplayName: req.DisplayName,
+			BlockedAt:         time.Now().UTC(),
+		}
+		if err := setProjectBlock(ctx, req.Storage, block); err != nil {
+			return nil, fmt.Errorf("error blocking issuance: %w", err)
+		}
+	}
+
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
+	}
+
+	creds, err := listCredentials(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	outstanding := []*RollbarCredentialEntry{}
+	for _, cred := range creds {
+		if cred.Type == roleTypeProject && cred.ProjectID == projectID {
+			outstanding = append(outstanding, cred)
+		}
+	}
+
+	revoked, failedCreds, err := b.revokeCredentials(ctx, req.Storage, outstanding)
+	if err != nil {
+		return nil, err
+	}
+
+	// errors of the cleanup steps, by step
+	errs := map[string]string{}
+
+	claims, err := b.dropProjectClaims(ctx, req.Storage, projectID)
+	if err != nil {
+		errs["claims"] = err.Error()
+	}
+
+	roles, pooled, err := b.drainProjectPools(ctx, req.Storage, client, projectID)
+	if err != nil {
+		errs["pools"] = err.Error()
+	}
+
+	if len(failedCreds) == 0 && len(errs) == 0 {
+		for _, role := range roles {
+			if err := dropReusableTokens(ctx, req.Storage, role); err != nil {
+				errs["reuse"] = err.Error()
+			}
+		}
+	}
+
+	revokedCreds := make([]*RollbarCredentialEntry, 0, len(revoked))
+	for _, cred := range outstanding {
+		if _, ok := failedCreds[cred.ID]; !ok {
+			revokedCreds = append(revokedCreds, cred)
+		}
+	}
+	leaseIDs, leasePrefixes := outstandingLeases(req, revokedCreds)
+
+	b.Logger().Warn("revoked all tokens for rollbar project", "project_id", projectID, "revoked", len(revoked), "failed", len(failedCreds), "errors", len(errs), "blocked", blocked)
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"project_id":         projectID,
+			"roles":              roles,
+			"revoked":            revoked,
+			"failed_credentials": failedCreds,
+			"errors":             errs,
+			"lease_ids":          leaseIDs,
+			"lease_prefixes":     leasePrefixes,
+			"claims_removed":     claims,
+			"pooled_deleted":     pooled,
+			"blocked":            blocked,
+		},
+	}
+	if len(revoked) > 0 {
+		resp.AddWarning(leasesRemainWarning)
+	}
+	if len(failedCreds) > 0 {
+		resp.AddWarning(fmt.Sprintf("%d tokens could not be revoked; run revoke-all again", len(failedCreds)))
+	}
+	if len(errs) > 0 {
+		resp.AddWarning(fmt.Sprintf("%d cleanup steps failed; run revoke-all again", len(errs)))
+	}
+
+	return resp, nil
+}
+
+// pathProjectsBlockRead returns the issuance block of a project
+func (b *RollbarBackend) pathProjectsBlockRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	block, err := getProjectBlock(ctx, req.Storage, d.Get("project_id").(int))
+	if err != nil {
+		return nil, err
+	}
+
+	if block == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: block.toResponseData(),
+	}, nil
+}
+
+// pathProjectsBlockDelete clears the issuance block of a project
+func (b *RollbarBackend) pathProjectsBlockDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	if err := req.Storage.Delete(ctx, projectBlockKey(d.Get("project_id").(int))); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// dropProjectClaims removes the pending claims for a project so their
+// handles can no longer be claimed, returning how many were removed
+func (b *RollbarBackend) dropProjectClaims(ctx context.Context, s logical.Storage, projectID int) (int, error) {
+	keys, err := s.List(ctx, claimStoragePath)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, k := range keys {
+		dropped, err := b.dropProjectClaim(ctx, s, claimStoragePath+k, projectID)
+		if err != nil {
+			return removed, err
+		}
+		if dropped {
+			removed++
+		}
+	}
+
+	return removed, nil
+}
+
+// dropProjectClaim removes a pending claim if it is for the project,
+// reporting whether it was removed
+func (b *RollbarBackend) dropProjectClaim(ctx context.Context, s logical.Storage, key string, projectID int) (bool, error) {
+	unlock := b.lockClaim(key)
+	defer unlock()
+
+	claim, err := getClaim(ctx, s, key)
+	if err != nil {
+		return false, err
+	}
+	if claim == nil || claim.ProjectID != projectID {
+		return false, nil
+	}
+
+	if err := s.Delete(ctx, key); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// drainProjectPools deletes the pooled tokens of every role issuing for a
+// project. It returns the names of those roles and how many pooled tokens
+// were deleted.
+func (b *RollbarBackend) drainProjectPools(ctx context.Context, s logical.Storage, client *rollbarClient, projectID int) ([]string, int, error) {
+	names, err := s.List(ctx, pathRoleDef)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	roles := []string{}
+	deleted := 0
+	for _, name := range names {
+		roleEntry, err := b.getRole(ctx, s, name)
+		if err != nil {
+			return roles, deleted, err
+		}
+		if roleEntry == nil || roleEntry.RoleType != roleTypeProject || roleEntry.ProjectID != projectID {
+			continue
+		}
+		roles = append(roles, name)
+
+		pooled, err := s.List(ctx, poolStoragePath+name+"/")
+		if err != nil {
+			return roles, deleted, err
+		}
+		if _, err := b.prunePool(ctx, s, client, roleEntry, 0); err != nil {
+			return roles, deleted, err
+		}
+		deleted += len(pooled)
+	}
+
+	return roles, deleted, nil
+}
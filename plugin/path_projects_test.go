@@ -0,0 +1,143 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestProjectsImportRoles(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeProjectTokens()
+	fake.tokens["pat-ci"] = rollbarProjectAccessToken{AccessToken: "pat-ci", Name: "ci", Status: tokenStatusEnabled, Scopes: []string{"write", "read"}}
+	_, config := newFakeRollbar(t, fake.ServeHTTP)
+	b, s := newTestBackend(t, config)
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "projects/1/import-roles",
+		Storage:   s,
+		Data:      map[string]interface{}{"dry_run": false},
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("importing roles: %v %v", resp, err)
+	}
+
+	roleEntry, err := b.getRole(ctx, s, "project-1-read-write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roleEntry == nil {
+		t.Fatalf("role not created, got %v", resp.Data)
+	}
+
+	want := &RollbarRoleEntry{
+		Name:                     "project-1-read-write",
+		RoleType:                 roleTypeProject,
+		ProjectID:                1,
+		ProjectAccessTokenScopes: []string{"read", "write"},
+		TTL:                      defaultTTL,
+		MaxTTL:                   defaultMaxTTL,
+		SchemaVersion:            roleSchemaVersion,
+	}
+	if !reflect.DeepEqual(roleEntry, want) {
+		t.Errorf("imported role is %+v, want %+v", roleEntry, want)
+	}
+}
+
+func TestProjectsImportRolesInvalidPrefix(t *testing.T) {
+	for _, prefix := range []string{"-web", "web/", "claim"} {
+		t.Run(prefix, func(t *testing.T) {
+			ctx := context.Background()
+			_, config := newFakeRollbar(t, newFakeProjectTokens().ServeHTTP)
+			b, s := newTestBackend(t, config)
+
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "projects/1/import-roles",
+				Storage:   s,
+				Data:      map[string]interface{}{"name_prefix": prefix, "dry_run": false},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !resp.IsError() {
+				t.Errorf("name_prefix %q accepted", prefix)
+			}
+		})
+	}
+}
+
+func TestClientSendsOneElementPerScope(t *testing.T) {
+	fake := newFakeProjectTokens()
+	_, config := newFakeRollbar(t, fake.ServeHTTP)
+
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.CreateProjectAccessToken(context.Background(), []string{"read", "write"}, 1, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := [][]string{{"read", "write"}}; !reflect.DeepEqual(fake.scopes, want) {
+		t.Errorf("rollbar received scopes %q, want %q", fake.scopes, want)
+	}
+}
+
+func TestProjectsRevokeAll(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeProjectTokens()
+	fake.tokens["pat-test-a"] = rollbarProjectAccessToken{AccessToken: "pat-test-a", Name: "test-a", Status: tokenStatusEnabled}
+	fake.tokens["pat-test-b"] = rollbarProjectAccessToken{AccessToken: "pat-test-b", Name: "test-b", Status: tokenStatusEnabled}
+	_, config := newFakeRollbar(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete && strings.HasSuffix(r.URL.Path, "/pat-test-b") {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fake.ServeHTTP(w, r)
+	})
+	b, s := newTestBackend(t, config)
+
+	creds := []*RollbarCredentialEntry{
+		{ID: "a", Type: roleTypeProject, Role: "test", ProjectID: 1, TokenName: "test-a", LeaseID: "rollbar/projectaccesstoken/test/lease-a"},
+		{ID: "b", Type: roleTypeProject, Role: "test", ProjectID: 1, TokenName: "test-b", LeaseID: "rollbar/projectaccesstoken/test/lease-b"},
+	}
+	for _, cred := range creds {
+		if err := setCredential(ctx, s, cred); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation:  logical.UpdateOperation,
+		Path:       "projects/1/revoke-all",
+		MountPoint: "rollbar/",
+		Storage:    s,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("revoking all tokens: %v %v", resp, err)
+	}
+
+	if revoked := resp.Data["revoked"].([]string); !reflect.DeepEqual(revoked, []string{"a"}) {
+		t.Errorf("revoked %v, want [a]", revoked)
+	}
+	failed := resp.Data["failed_credentials"].(map[string]string)
+	if _, ok := failed["b"]; !ok || len(failed) != 1 {
+		t.Errorf("failed_credentials is %v, want only b", failed)
+	}
+	if errs := resp.Data["errors"].(map[string]string); len(errs) != 0 {
+		t.Errorf("cleanup errors %v", errs)
+	}
+	if leaseIDs := resp.Data["lease_ids"].([]string); !reflect.DeepEqual(leaseIDs, []string{"rollbar/projectaccesstoken/test/lease-a"}) {
+		t.Errorf("lease_ids is %v, want the lease of a", leaseIDs)
+	}
+	if prefixes := resp.Data["lease_prefixes"].([]string); !reflect.DeepEqual(prefixes, []string{"rollbar/projectaccesstoken/test/"}) {
+		t.Errorf("lease_prefixes is %v", prefixes)
+	}
+}
@@ -0,0 +1,144 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	pathQuotasDef             = "quotas/"
+	pathQuotasHelpSynopsis    = "View and reset an entity's usage of a role's per-entity quotas."
+	pathQuotasHelpDescription = `
+	Reading quotas/<role>/<entity_id> returns the number of tokens the entity
+	holds from the role and how many it was issued within the role's issuance
+	rate window, next to the role's max_tokens_per_entity and
+	max_issuance_rate_per_entity. Deleting it resets the entity's issuance rate
+	window. Tokens the entity holds only stop counting once they are revoked.
+
+	List quotas/<role>/ to see the entities with recorded usage of a role.
+	`
+)
+
+func pathQuotas(b *RollbarBackend) []*framework.Path {
+
+	return []*framework.Path{
+		{
+			Pattern: pathQuotasDef + framework.GenericNameRegex("role") + "/" + framework.GenericNameRegex("entity_id"),
+			Fields: map[string]*framework.FieldSchema{
+				"role": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Required. Name of the role",
+					Required:    true,
+				},
+				"entity_id": {
+					Type:        framework.TypeString,
+					Description: "Required. ID of the Vault entity",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathQuotasRead,
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathQuotasDelete,
+				},
+			},
+			HelpSynopsis:    pathQuotasHelpSynopsis,
+			HelpDescription: pathQuotasHelpDescription,
+		},
+		{
+			Pattern: pathQuotasDef + framework.GenericNameRegex("role") + "/?$",
+			Fields: map[string]*framework.FieldSchema{
+				"role": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Required. Name of the role",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathQuotasList,
+				},
+			},
+			HelpSynopsis:    pathQuotasHelpSynopsis,
+			HelpDescription: pathQuotasHelpDescription,
+		},
+	}
+}
+
+// pathQuotasList returns the entities with recorded usage of a role
+func (b *RollbarBackend) pathQuotasList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	entries, err := req.Storage.List(ctx, quotaStoragePath+d.Get("role").(string)+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
+// pathQuotasRead returns an entity's usage of a role's quotas
+func (b *RollbarBackend) pathQuotasRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	role := d.Get("role").(string)
+	entityID := strings.TrimSpace(d.Get("entity_id").(string))
+
+	roleEntry, err := b.getRole(ctx, req.Storage, role)
+	if err != nil {
+		return nil, err
+	}
+
+	if roleEntry == nil {
+		return logical.ErrorResponse("role %q not found", role), nil
+	}
+
+	held, err := countEntityTokens(ctx, req.Storage, role, entityID)
+	if err != nil {
+		return nil, err
+	}
+
+	usage, err := getEntityUsage(ctx, req.Storage, role, entityID)
+	if err != nil {
+		return nil, err
+	}
+
+	window := roleEntry.issuanceRateWindow()
+	issued := usage.inWindow(window)
+
+	data := map[string]interface{}{
+		"role":                         role,
+		"entity_id":                    entityID,
+		"active_tokens":                held,
+		"max_tokens_per_entity":        roleEntry.MaxTokensPerEntity,
+		"issued_in_window":             len(issued),
+		"max_issuance_rate_per_entity": roleEntry.MaxIssuanceRatePerEntity,
+		"issuance_rate_window":         window.Seconds(),
+	}
+	if len(issued) > 0 {
+		data["window_resets_at"] = issued[0].Add(window)
+	}
+
+	return &logical.Response{
+		Data: data,
+	}, nil
+}
+
+// pathQuotasDelete resets an entity's issuance rate window for a role
+func (b *RollbarBackend) pathQuotasDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	role := d.Get("role").(string)
+	entityID := strings.TrimSpace(d.Get("entity_id").(string))
+
+	unlock := b.lockQuota(role, entityID)
+	defer unlock()
+
+	if err := req.Storage.Delete(ctx, quotaKey(role, entityID)); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,68 @@
+package plugin
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	pathReconcileDef             = "reconcile"
+	pathReconcileHelpSynopsis    = "Reconcile issued tokens with their state in rollbar."
+	pathReconcileHelpDescription = `
+	Reading this path returns the report of the last reconciliation run. Writing
+	to it runs a reconciliation immediately and returns the new report.
+
+	Reconciliation compares every token issued by the backend with the tokens
+	rollbar reports for its project and flags tokens that are missing, disabled
+	or whose scopes no longer match the ones they were issued with.
+	`
+)
+
+func pathReconcile(b *RollbarBackend) *framework.Path {
+
+	return &framework.Path{
+		Pattern: pathReconcileDef,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathReconcileRead,
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathReconcileWrite,
+			},
+		},
+		HelpSynopsis:    pathReconcileHelpSynopsis,
+		HelpDescription: pathReconcileHelpDescription,
+	}
+}
+
+// pathReconcileRead returns the last reconciliation report
+func (b *RollbarBackend) pathReconcileRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	report, err := getReconcileReport(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if report == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: report.toResponseData(),
+	}, nil
+}
+
+// pathReconcileWrite runs a reconciliation on demand
+func (b *RollbarBackend) pathReconcileWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	report, err := b.reconcile(ctx, req.Storage)
+	if err != nil {
+		return nil, rollbarCodedError(err, "error reconciling issued tokens")
+	}
+
+	return &logical.Response{
+		Data: report.toResponseData(),
+	}, nil
+}
@@ -3,6 +3,7 @@ package plugin
 import (
 	"context"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
@@ -11,16 +12,46 @@ import (
 
 const (
 	pathRoleDef             = "roles/"
-	pathRoleHelpSynopsis    = "Manages the Vault role for generating rollbar project access tokens."
+	pathRoleHelpSynopsis    = "Manages the Vault role for generating rollbar project or account access tokens."
 	pathRoleHelpDescription = `
 	This path allows you to read and write roles used to generate rollbar project access tokens.
 	You can configure scopes associated with project access tokens by providing a list of scopes with the 
 	input data.
+
+	Roles with role_type=account generate rollbar account access tokens instead, with the
+	account scopes listed in account_access_token_scopes.
+
+	A role with outstanding tokens can only be deleted with revoke_outstanding=true,
+	which deletes those tokens in rollbar first, or with force=true, which leaves
+	them valid until their leases expire.
+
+	revoke_outstanding does not revoke the Vault leases of the deleted tokens: a
+	backend cannot revoke leases. They stay listed in sys/leases until they expire,
+	and revoking them then succeeds without contacting rollbar. The response lists
+	their known lease IDs and the prefix they were issued under, to remove them
+	with vault lease revoke -prefix.
 	`
 	pathRoleListHelpSynopsis    = "List the existing roles in rollbar backend"
-	pathRoleListHelpDescription = "Roles will be listed by the role name."
-	defaultMaxTTL               = time.Second * 7200
-	defaultTTL                  = time.Second * 3600
+	pathRoleListHelpDescription = `
+	Roles will be listed by the role name, with their type, project ID, scopes,
+	TTLs, description, tags, owner and metadata. The listing can be filtered by
+	project ID, scope, tag, owner or metadata pairs, and paged with after, the
+	name to list from, and limit.
+	`
+	defaultMaxTTL = time.Second * 7200
+	defaultTTL    = time.Second * 3600
+)
+
+const (
+	roleTypeProject = "project"
+	roleTypeAccount = "account"
+)
+
+var (
+	accountAccessTokenScopes = []string{
+		"read",
+		"write",
+	}
 )
 
 // var (
@@ -36,11 +67,35 @@ const (
 // a Vault role for interoperating with the rollbar
 // api
 type RollbarRoleEntry struct {
-	Name                     string        `json:"name"`
-	ProjectID                int           `json:"project_id"`
-	ProjectAccessTokenScopes string        `json:"project_access_token_scopes"`
-	TTL                      time.Duration `json:"ttl"`
-	MaxTTL                   time.Duration `json:"max_ttl"`
+	Name                     string            `json:"name"`
+	RoleType                 string            `json:"role_type"`
+	ProjectID                int               `json:"project_id"`
+	ProjectAccessTokenScopes []string          `json:"project_access_token_scope_list"`
+	AccountAccessTokenScopes []string          `json:"account_access_token_scope_list"`
+	TTL                      time.Duration     `json:"ttl"`
+	MaxTTL                   time.Duration     `json:"max_ttl"`
+	MaxBatchSize             int               `json:"max_batch_size"`
+	PoolSize                 int               `json:"pool_size"`
+	PoolMaxAge               time.Duration     `json:"pool_max_age"`
+	ReuseForEntity           bool              `json:"reuse_for_entity"`
+	ReuseMinRemaining        float64           `json:"reuse_min_remaining"`
+	MaxActiveTokens          int               `json:"max_active_tokens"`
+	MaxTokensPerEntity       int               `json:"max_tokens_per_entity"`
+	MaxIssuanceRatePerEntity int               `json:"max_issuance_rate_per_entity"`
+	IssuanceRateWindow       time.Duration     `json:"issuance_rate_window"`
+	Description              string            `json:"description"`
+	Tags                     []string          `json:"tags"`
+	Owner                    string            `json:"owner"`
+	Metadata                 map[string]string `json:"metadata"`
+	ActivateOnClaim          bool              `json:"activate_on_claim"`
+	ClaimWindow              time.Duration     `json:"claim_window"`
+	SchemaVersion            int               `json:"schema_version"`
+
+	// LegacyProjectAccessTokenScopes and LegacyAccountAccessTokenScopes hold
+	// the comma joined scopes of entries stored before schema version 2.
+	// They are only read by migrateRoleV2.
+	LegacyProjectAccessTokenScopes string `json:"project_access_token_scopes,omitempty"`
+	LegacyAccountAccessTokenScopes string `json:"account_access_token_scopes,omitempty"`
 }
 
 func pathRole(b *RollbarBackend) []*framework.Path {
@@ -48,30 +103,7 @@ func pathRole(b *RollbarBackend) []*framework.Path {
 	return []*framework.Path{
 		{
 			Pattern: pathRoleDef + framework.GenericNameRegex("name"),
-			Fields: map[string]*framework.FieldSchema{
-				"name": {
-					Type:        framework.TypeLowerCaseString,
-					Description: "Required. Name of the role",
-					Required:    true,
-				},
-				"project_id": {
-					Type:        framework.TypeInt,
-					Description: "Required. Rollbar project ID",
-					Required:    true,
-				},
-				"project_access_token_scopes": {
-					Type:        framework.TypeString,
-					Description: "Optional, List of project scopes to be applied to the access token",
-				},
-				"ttl": {
-					Type:        framework.TypeDurationSecond,
-					Description: "Optional, Default least time for the generated project access token. If not set or set to 0, system default will be used.",
-				},
-				"max_ttl": {
-					Type:        framework.TypeDurationSecond,
-					Description: "Optional. Maximum lease time for role. If not set or set to 0, system default will be used.",
-				},
-			},
+			Fields:  roleFields(),
 			Operations: map[logical.Operation]framework.OperationHandler{
 				logical.ReadOperation: &framework.PathOperation{
 					Callback: b.pathRolesRead,
@@ -92,6 +124,36 @@ func pathRole(b *RollbarBackend) []*framework.Path {
 		},
 		{
 			Pattern: pathRoleDef + "?$",
+			Fields: map[string]*framework.FieldSchema{
+				"project_id": {
+					Type:        framework.TypeInt,
+					Description: "Optional. Only list roles for this rollbar project",
+				},
+				"scope": {
+					Type:        framework.TypeString,
+					Description: "Optional. Only list roles granting this scope",
+				},
+				"tag": {
+					Type:        framework.TypeString,
+					Description: "Optional. Only list roles with this tag",
+				},
+				"owner": {
+					Type:        framework.TypeString,
+					Description: "Optional. Only list roles with this owner",
+				},
+				"metadata": {
+					Type:        framework.TypeKVPairs,
+					Description: "Optional. Only list roles with all of these key=value metadata pairs",
+				},
+				"after": {
+					Type:        framework.TypeString,
+					Description: "Optional. Only list roles whose name sorts after this one",
+				},
+				"limit": {
+					Type:        framework.TypeInt,
+					Description: "Optional. Maximum number of roles listed. If not set or set to 0, every role is listed.",
+				},
+			},
 			Operations: map[logical.Operation]framework.OperationHandler{
 				logical.ListOperation: &framework.PathOperation{
 					Callback: b.pathRolesList,
@@ -103,15 +165,182 @@ func pathRole(b *RollbarBackend) []*framework.Path {
 	}
 }
 
+// roleFields returns the schema of the fields of a role
+func roleFields() map[string]*framework.FieldSchema {
+
+	return map[string]*framework.FieldSchema{
+		"name": {
+			Type:        framework.TypeLowerCaseString,
+			Description: "Required. Name of the role",
+			Required:    true,
+		},
+		"role_type": {
+			Type:          framework.TypeLowerCaseString,
+			Description:   "Optional. Type of token issued by the role, either project or account. Defaults to project.",
+			Default:       roleTypeProject,
+			AllowedValues: []interface{}{roleTypeProject, roleTypeAccount},
+		},
+		"project_id": {
+			Type:        framework.TypeInt,
+			Description: "Rollbar project ID. Required for project roles.",
+		},
+		"project_access_token_scopes": {
+			Type:        framework.TypeCommaStringSlice,
+			Description: "Optional, List of project scopes to be applied to the access token",
+		},
+		"account_access_token_scopes": {
+			Type:        framework.TypeCommaStringSlice,
+			Description: "List of account scopes to be applied to the access token. Required for account roles.",
+		},
+		"ttl": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Optional, Default least time for the generated project access token. If not set or set to 0, system default will be used.",
+		},
+		"max_ttl": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Optional. Maximum lease time for role. If not set or set to 0, system default will be used.",
+		},
+		"max_batch_size": {
+			Type:        framework.TypeInt,
+			Description: "Optional. Maximum number of project access tokens issued by one batch request. If not set or set to 0, batch issuance is disabled for the role.",
+		},
+		"pool_size": {
+			Type:        framework.TypeInt,
+			Description: "Optional. Number of project access tokens kept pre-created for the role and handed out first. If not set or set to 0, tokens are always created on request.",
+		},
+		"pool_max_age": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Optional. Age after which a pooled token is discarded and deleted instead of handed out. Defaults to 1 hour.",
+		},
+		"reuse_for_entity": {
+			Type:        framework.TypeBool,
+			Description: "Optional. Hand out the token issued earlier to the same entity instead of creating a new one while enough of its lease remains.",
+		},
+		"reuse_min_remaining": {
+			Type:        framework.TypeFloat,
+			Description: "Optional. Fraction of its first lease a token must have left to be reused, between 0 and 1. Defaults to 0.5.",
+		},
+		"max_active_tokens": {
+			Type:        framework.TypeInt,
+			Description: "Optional. Maximum number of rollbar tokens the role may hold at once, pooled tokens included. If not set or set to 0, there is no limit.",
+		},
+		"max_tokens_per_entity": {
+			Type:        framework.TypeInt,
+			Description: "Optional. Maximum number of tokens a single Vault entity may hold from the role at once. If not set or set to 0, there is no limit.",
+		},
+		"max_issuance_rate_per_entity": {
+			Type:        framework.TypeInt,
+			Description: "Optional. Maximum number of tokens issued to a single Vault entity from the role per issuance_rate_window. If not set or set to 0, there is no limit.",
+		},
+		"issuance_rate_window": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Optional. Window of max_issuance_rate_per_entity. Defaults to 1 hour.",
+		},
+		"description": {
+			Type:        framework.TypeString,
+			Description: "Optional. Description of the role",
+		},
+		"tags": {
+			Type:        framework.TypeCommaStringSlice,
+			Description: "Optional. Tags to find the role by when listing roles",
+		},
+		"owner": {
+			Type:        framework.TypeString,
+			Description: "Optional. Team or person to ask before changing the role",
+		},
+		"metadata": {
+			Type:        framework.TypeKVPairs,
+			Description: "Optional. Free-form key=value metadata of the role, copied onto the tokens it issues",
+		},
+		"activate_on_claim": {
+			Type:        framework.TypeBool,
+			Description: "Optional. Create tokens disabled and return a one-time claim handle that enables the token when written to projectaccesstoken/claim.",
+		},
+		"claim_window": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Optional. Time a token issued with activate_on_claim may wait to be claimed before it is deleted. Defaults to 15 minutes.",
+		},
+		"revoke_outstanding": {
+			Type:        framework.TypeBool,
+			Description: "Optional. On delete, delete in rollbar every token still outstanding for the role before deleting it. Their leases are not revoked.",
+		},
+		"force": {
+			Type:        framework.TypeBool,
+			Description: "Optional. On delete, delete the role even if tokens issued from it are still outstanding.",
+		},
+	}
+}
+
 // pathRolesList lists the rollbar roleEntries
 func (b *RollbarBackend) pathRolesList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 
+	projectID := d.Get("project_id").(int)
+	scope := d.Get("scope").(string)
+	tag := d.Get("tag").(string)
+	owner := d.Get("owner").(string)
+	metadata := d.Get("metadata").(map[string]string)
+	after := d.Get("after").(string)
+	limit := d.Get("limit").(int)
+
+	if limit < 0 {
+		return logical.ErrorResponse("limit cannot be negative"), nil
+	}
+
 	entries, err := req.Storage.List(ctx, pathRoleDef)
 	if err != nil {
 		return nil, err
 	}
+	sort.Strings(entries)
 
-	return logical.ListResponse(entries), nil
+	keys := []string{}
+	keyInfo := map[string]interface{}{}
+	for _, name := range entries {
+		if after != "" && name <= after {
+			continue
+		}
+		if limit > 0 && len(keys) == limit {
+			break
+		}
+
+		roleEntry, err := b.getRole(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if roleEntry == nil {
+			continue
+		}
+
+		if projectID != 0 && roleEntry.ProjectID != projectID {
+			continue
+		}
+		if scope != "" && !contains(roleEntry.scopes(), scope) {
+			continue
+		}
+		if tag != "" && !contains(roleEntry.Tags, tag) {
+			continue
+		}
+		if owner != "" && roleEntry.Owner != owner {
+			continue
+		}
+		if !matchesMetadata(roleEntry.Metadata, metadata) {
+			continue
+		}
+
+		keys = append(keys, name)
+		keyInfo[name] = map[string]interface{}{
+			"role_type":   roleEntry.RoleType,
+			"project_id":  roleEntry.ProjectID,
+			"scopes":      roleEntry.scopes(),
+			"ttl":         roleEntry.TTL.Seconds(),
+			"max_ttl":     roleEntry.MaxTTL.Seconds(),
+			"description": roleEntry.Description,
+			"tags":        roleEntry.Tags,
+			"owner":       roleEntry.Owner,
+			"metadata":    roleEntry.Metadata,
+		}
+	}
+
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
 }
 
 // pathRolesRead returns a specifc rollbar roleEntry
@@ -126,22 +355,31 @@ func (b *RollbarBackend) pathRolesRead(ctx context.Context, req *logical.Request
 		return nil, nil
 	}
 
-	return &logical.Response{
+	active, err := b.countActiveTokens(ctx, req.Storage, entry.Name)
+	if err != nil {
+		return nil, fmt.Errorf("error counting active tokens: %w", err)
+	}
+
+	resp := &logical.Response{
 		Data: entry.toResponseData(),
-	}, nil
+	}
+	resp.Data["active_tokens"] = active
+
+	return resp, nil
 }
 
 // pathRolesWrite creates or updates a rollbar roleEntry
 func (b *RollbarBackend) pathRolesWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 
+	b.syncLock.RLock()
+	defer b.syncLock.RUnlock()
+
 	name := d.Get("name").(string)
 	if name == "" {
 		return logical.ErrorResponse("missing role name"), nil
 	}
-
-	projectID, ok := d.Get("project_id").(int)
-	if !ok || projectID == 0 {
-		return logical.ErrorResponse("missing project ID"), nil
+	if reservedRoleName(name) {
+		return logical.ErrorResponse("role name %q is reserved", name), nil
 	}
 
 	roleEntry, err := b.getRole(ctx, req.Storage, name)
@@ -150,18 +388,93 @@ func (b *RollbarBackend) pathRolesWrite(ctx context.Context, req *logical.Reques
 	}
 	if roleEntry == nil {
 		roleEntry = &RollbarRoleEntry{
-			TTL:    defaultTTL,
-			MaxTTL: defaultMaxTTL,
+			RoleType: roleTypeProject,
+			TTL:      defaultTTL,
+			MaxTTL:   defaultMaxTTL,
 		}
 	}
 
 	roleEntry.Name = name
-	roleEntry.ProjectID = projectID
 
 	createOperation := (req.Operation == logical.CreateOperation)
+	if resp := applyRoleFields(roleEntry, d, createOperation); resp != nil {
+		return resp, nil
+	}
+
+	if err := setRole(ctx, req.Storage, name, roleEntry); err != nil {
+		return nil, err
+	}
+
+	if err := b.recordVersion(ctx, req, versionsRoleKey(name), roleEntry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// reservedRoleName reports whether a role name collides with a fixed path
+// under projectaccesstoken/
+func reservedRoleName(name string) bool {
+	return name == "claim"
+}
+
+// scopes returns the scopes granted by the tokens a role issues
+func (r *RollbarRoleEntry) scopes() []string {
+	if r.RoleType == roleTypeAccount {
+		return append([]string{}, r.AccountAccessTokenScopes...)
+	}
+	return append([]string{}, r.ProjectAccessTokenScopes...)
+}
+
+// annotate copies the description, owner and metadata of a role into the
+// data and internal data of a secret issued from it
+func (r *RollbarRoleEntry) annotate(resp *logical.Response) {
+	resp.Data["description"] = r.Description
+	resp.Data["owner"] = r.Owner
+	resp.Data["metadata"] = r.Metadata
+
+	resp.Secret.InternalData["description"] = r.Description
+	resp.Secret.InternalData["owner"] = r.Owner
+	resp.Secret.InternalData["metadata"] = r.Metadata
+}
+
+// applyRoleFields sets the fields given in d on a role entry and validates
+// the result. It returns an error response when the role is invalid.
+func applyRoleFields(roleEntry *RollbarRoleEntry, d *framework.FieldData, createOperation bool) *logical.Response {
+
+	if roleType, ok := d.GetOk("role_type"); ok {
+		roleEntry.RoleType = roleType.(string)
+	} else if createOperation {
+		roleEntry.RoleType = d.Get("role_type").(string)
+	}
+
+	if projectID, ok := d.GetOk("project_id"); ok {
+		roleEntry.ProjectID = projectID.(int)
+	}
+
+	switch roleEntry.RoleType {
+	case roleTypeProject:
+		if roleEntry.ProjectID == 0 {
+			return logical.ErrorResponse("missing project ID")
+		}
+	case roleTypeAccount:
+		if scopes, ok := d.GetOk("account_access_token_scopes"); ok {
+			roleEntry.AccountAccessTokenScopes = normalizeScopes(scopes.([]string))
+		}
+		if len(roleEntry.AccountAccessTokenScopes) == 0 {
+			return logical.ErrorResponse("missing account access token scopes")
+		}
+		for _, scope := range roleEntry.AccountAccessTokenScopes {
+			if !contains(accountAccessTokenScopes, scope) {
+				return logical.ErrorResponse("provided scope %s is not a valid rollbar account access token scope", scope)
+			}
+		}
+	default:
+		return logical.ErrorResponse("invalid role_type %q, must be %q or %q", roleEntry.RoleType, roleTypeProject, roleTypeAccount)
+	}
 
 	if scopes, ok := d.GetOk("project_access_token_scopes"); ok {
-		roleEntry.ProjectAccessTokenScopes = scopes.(string)
+		roleEntry.ProjectAccessTokenScopes = normalizeScopes(scopes.([]string))
 		// check validity of provided scopes
 		// for _, scope := range roleEntry.ProjectAccessTokenScopes {
 		// 	valid := contains(projectAccessTokenScopes, scope)
@@ -170,7 +483,7 @@ func (b *RollbarBackend) pathRolesWrite(ctx context.Context, req *logical.Reques
 		// 	}
 		// }
 	} else if createOperation {
-		roleEntry.ProjectAccessTokenScopes = d.Get("project_access_token_scopes").(string)
+		roleEntry.ProjectAccessTokenScopes = normalizeScopes(d.Get("project_access_token_scopes").([]string))
 		// check validity of provided scopes
 		// for _, scope := range roleEntry.ProjectAccessTokenScopes {
 		// 	valid := contains(projectAccessTokenScopes, scope)
@@ -192,64 +505,250 @@ func (b *RollbarBackend) pathRolesWrite(ctx context.Context, req *logical.Reques
 		roleEntry.MaxTTL = time.Duration(d.Get("max_ttl").(int)) * time.Second
 	}
 
-	if roleEntry.MaxTTL != 0 && roleEntry.TTL > roleEntry.MaxTTL {
-		return logical.ErrorResponse("ttl cannot be greater than max_ttl"), nil
+	if maxBatchSize, ok := d.GetOk("max_batch_size"); ok {
+		roleEntry.MaxBatchSize = maxBatchSize.(int)
 	}
 
-	if err := setRole(ctx, req.Storage, name, roleEntry); err != nil {
-		return nil, err
+	if poolSize, ok := d.GetOk("pool_size"); ok {
+		roleEntry.PoolSize = poolSize.(int)
 	}
 
-	return nil, nil
+	if poolMaxAge, ok := d.GetOk("pool_max_age"); ok {
+		roleEntry.PoolMaxAge = time.Duration(poolMaxAge.(int)) * time.Second
+	}
+
+	if roleEntry.PoolSize < 0 || roleEntry.PoolSize > maxPoolSizeLimit {
+		return logical.ErrorResponse("pool_size must be between 0 and %d", maxPoolSizeLimit)
+	}
+
+	if roleEntry.PoolSize > 0 && roleEntry.RoleType != roleTypeProject {
+		return logical.ErrorResponse("pool_size is only supported on project roles")
+	}
+
+	if reuse, ok := d.GetOk("reuse_for_entity"); ok {
+		roleEntry.ReuseForEntity = reuse.(bool)
+	}
+
+	if minRemaining, ok := d.GetOk("reuse_min_remaining"); ok {
+		roleEntry.ReuseMinRemaining = minRemaining.(float64)
+	}
+
+	if roleEntry.ReuseMinRemaining < 0 || roleEntry.ReuseMinRemaining >= 1 {
+		return logical.ErrorResponse("reuse_min_remaining must be between 0 and 1")
+	}
+
+	if roleEntry.ReuseForEntity && roleEntry.RoleType != roleTypeProject {
+		return logical.ErrorResponse("reuse_for_entity is only supported on project roles")
+	}
+
+	if description, ok := d.GetOk("description"); ok {
+		roleEntry.Description = description.(string)
+	}
+
+	if tags, ok := d.GetOk("tags"); ok {
+		roleEntry.Tags = tags.([]string)
+		if len(roleEntry.Tags) == 0 {
+			roleEntry.Tags = nil
+		}
+	}
+
+	if owner, ok := d.GetOk("owner"); ok {
+		roleEntry.Owner = owner.(string)
+	}
+
+	if metadata, ok := d.GetOk("metadata"); ok {
+		roleEntry.Metadata = metadata.(map[string]string)
+		if len(roleEntry.Metadata) == 0 {
+			roleEntry.Metadata = nil
+		}
+	}
+
+	if activate, ok := d.GetOk("activate_on_claim"); ok {
+		roleEntry.ActivateOnClaim = activate.(bool)
+	}
+
+	if window, ok := d.GetOk("claim_window"); ok {
+		roleEntry.ClaimWindow = time.Duration(window.(int)) * time.Second
+	}
+
+	if roleEntry.ClaimWindow < 0 {
+		return logical.ErrorResponse("claim_window cannot be negative")
+	}
+
+	if roleEntry.ActivateOnClaim {
+		switch {
+		case roleEntry.RoleType != roleTypeProject:
+			return logical.ErrorResponse("activate_on_claim is only supported on project roles")
+		case roleEntry.PoolSize > 0:
+			return logical.ErrorResponse("activate_on_claim cannot be combined with pool_size")
+		case roleEntry.ReuseForEntity:
+			return logical.ErrorResponse("activate_on_claim cannot be combined with reuse_for_entity")
+		case roleEntry.MaxBatchSize > 0:
+			return logical.ErrorResponse("activate_on_claim cannot be combined with max_batch_size")
+		}
+	}
+
+	if maxActive, ok := d.GetOk("max_active_tokens"); ok {
+		roleEntry.MaxActiveTokens = maxActive.(int)
+	}
+
+	if maxPerEntity, ok := d.GetOk("max_tokens_per_entity"); ok {
+		roleEntry.MaxTokensPerEntity = maxPerEntity.(int)
+	}
+
+	if maxRate, ok := d.GetOk("max_issuance_rate_per_entity"); ok {
+		roleEntry.MaxIssuanceRatePerEntity = maxRate.(int)
+	}
+
+	if window, ok := d.GetOk("issuance_rate_window"); ok {
+		roleEntry.IssuanceRateWindow = time.Duration(window.(int)) * time.Second
+	}
+
+	if roleEntry.MaxTokensPerEntity < 0 || roleEntry.MaxIssuanceRatePerEntity < 0 || roleEntry.IssuanceRateWindow < 0 {
+		return logical.ErrorResponse("per-entity quotas cannot be negative")
+	}
+
+	if roleEntry.MaxActiveTokens < 0 {
+		return logical.ErrorResponse("max_active_tokens cannot be negative")
+	}
+
+	if roleEntry.MaxActiveTokens > 0 && roleEntry.PoolSize > roleEntry.MaxActiveTokens {
+		return logical.ErrorResponse("pool_size cannot be greater than max_active_tokens")
+	}
+
+	if roleEntry.MaxBatchSize < 0 || roleEntry.MaxBatchSize > maxBatchSizeLimit {
+		return logical.ErrorResponse("max_batch_size must be between 0 and %d", maxBatchSizeLimit)
+	}
+
+	if roleEntry.MaxTTL != 0 && roleEntry.TTL > roleEntry.MaxTTL {
+		return logical.ErrorResponse("ttl cannot be greater than max_ttl")
+	}
+
+	return nil
 }
 
-// pathRolesDelete deletes a rollbar roleEntry
+// pathRolesDelete deletes a rollbar roleEntry. A role with outstanding
+// tokens is only deleted when they are revoked first or force is set.
 func (b *RollbarBackend) pathRolesDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 
-	err := req.Storage.Delete(ctx, pathRoleDef+d.Get("name").(string))
+	b.syncLock.RLock()
+	defer b.syncLock.RUnlock()
+
+	name := d.Get("name").(string)
+
+	creds, err := listCredentials(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	outstanding := []*RollbarCredentialEntry{}
+	for _, cred := range creds {
+		if cred.Role == name {
+			outstanding = append(outstanding, cred)
+		}
+	}
+
+	var resp *logical.Response
+	if len(outstanding) > 0 {
+		switch {
+		case d.Get("revoke_outstanding").(bool):
+			revoked, failed, err := b.revokeCredentials(ctx, req.Storage, outstanding)
+			if err != nil {
+				return nil, err
+			}
+			leaseIDs, leasePrefixes := outstandingLeases(req, outstanding)
+			if len(failed) > 0 {
+				resp = logical.ErrorResponse("failed to delete %d outstanding tokens, role %q was not deleted", len(failed), name)
+				resp.Data["tokens_deleted"] = revoked
+				resp.Data["failed"] = failed
+				return resp, nil
+			}
+			if err := dropReusableTokens(ctx, req.Storage, name); err != nil {
+				return nil, fmt.Errorf("error removing reusable tokens: %w", err)
+			}
+			resp = &logical.Response{
+				Data: map[string]interface{}{
+					"tokens_deleted": revoked,
+					"lease_ids":      leaseIDs,
+					"lease_prefixes": leasePrefixes,
+				},
+			}
+			resp.AddWarning(leasesRemainWarning)
+		case d.Get("force").(bool):
+			resp = &logical.Response{}
+			resp.AddWarning(fmt.Sprintf("role deleted with %d outstanding tokens; they remain valid until their leases expire", len(outstanding)))
+		default:
+			return logical.ErrorResponse("role %q has %d outstanding tokens; set revoke_outstanding=true to revoke them or force=true to delete the role anyway", name, len(outstanding)), nil
+		}
+	}
+
+	err = req.Storage.Delete(ctx, pathRoleDef+name)
 	if err != nil {
 		return nil, fmt.Errorf("error deleting rollbar role: %w", err)
 	}
 
-	return nil, nil
+	if err := b.recordVersion(ctx, req, versionsRoleKey(name), nil); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
 }
 
+// PathRolesExistenceCheck reports whether the named role exists, so writes
+// to an existing role are updates that keep the fields they omit
 func (b *RollbarBackend) PathRolesExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
 
-	out, err := req.Storage.Get(ctx, pathRoleDef)
+	roleEntry, err := b.getRole(ctx, req.Storage, data.Get("name").(string))
 	if err != nil {
 		return false, fmt.Errorf("existence check failed: %w", err)
 	}
-	return out != nil, nil
+	return roleEntry != nil, nil
 }
 
 // getRole gets the role from the Vault storage API
 func (b *RollbarBackend) getRole(ctx context.Context, s logical.Storage, name string) (*RollbarRoleEntry, error) {
 
+	role, _, err := b.readRole(ctx, s, name)
+	return role, err
+}
+
+// readRole gets the role from the Vault storage API upgraded to the current
+// schema, along with the schema version it was stored in
+func (b *RollbarBackend) readRole(ctx context.Context, s logical.Storage, name string) (*RollbarRoleEntry, int, error) {
+
 	if name == "" {
-		return nil, fmt.Errorf("missing role name")
+		return nil, 0, fmt.Errorf("missing role name")
 	}
 
 	entry, err := s.Get(ctx, pathRoleDef+name)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	if entry == nil {
-		return nil, nil
+		return nil, 0, nil
 	}
 
 	var role RollbarRoleEntry
 	if err := entry.DecodeJSON(&role); err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+
+	stored := role.SchemaVersion
+	if err := upgradeRole(&role); err != nil {
+		return nil, 0, err
 	}
 
-	return &role, nil
+	return &role, stored, nil
 }
 
 // setRole sets the role into the Vault storage API
 func setRole(ctx context.Context, s logical.Storage, name string, roleEntry *RollbarRoleEntry) error {
 
+	if err := upgradeRole(roleEntry); err != nil {
+		return err
+	}
+
 	entry, err := logical.StorageEntryJSON(pathRoleDef+name, roleEntry)
 	if err != nil {
 		return err
@@ -276,9 +775,26 @@ func setRole(ctx context.Context, s logical.Storage, name string, roleEntry *Rol
 func (r *RollbarRoleEntry) toResponseData() map[string]interface{} {
 
 	return map[string]interface{}{
-		"project_id":                  r.ProjectID,
-		"project_access_token_scopes": r.ProjectAccessTokenScopes,
-		"ttl":                         r.TTL.Seconds(),
-		"max_ttl":                     r.MaxTTL.Seconds(),
+		"role_type":                    r.RoleType,
+		"project_id":                   r.ProjectID,
+		"project_access_token_scopes":  append([]string{}, r.ProjectAccessTokenScopes...),
+		"account_access_token_scopes":  append([]string{}, r.AccountAccessTokenScopes...),
+		"ttl":                          r.TTL.Seconds(),
+		"max_ttl":                      r.MaxTTL.Seconds(),
+		"max_batch_size":               r.MaxBatchSize,
+		"pool_size":                    r.PoolSize,
+		"pool_max_age":                 r.PoolMaxAge.Seconds(),
+		"reuse_for_entity":             r.ReuseForEntity,
+		"reuse_min_remaining":          r.ReuseMinRemaining,
+		"max_active_tokens":            r.MaxActiveTokens,
+		"max_tokens_per_entity":        r.MaxTokensPerEntity,
+		"max_issuance_rate_per_entity": r.MaxIssuanceRatePerEntity,
+		"issuance_rate_window":         r.IssuanceRateWindow.Seconds(),
+		"description":                  r.Description,
+		"tags":                         r.Tags,
+		"owner":                        r.Owner,
+		"metadata":                     r.Metadata,
+		"activate_on_claim":            r.ActivateOnClaim,
+		"claim_window":                 r.ClaimWindow.Seconds(),
 	}
 }
@@ -0,0 +1,379 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	pathRolesSyncDef             = "roles-sync"
+	pathRolesSyncHelpSynopsis    = "Make the backend's roles match a declared set of role definitions."
+	pathRolesSyncHelpDescription = `
+	Write the full desired set of roles as a map of role name to role fields, in
+	the format returned by roles-export. Every definition is validated before
+	anything is changed; a role's omitted fields take their defaults, as when
+	creating it. The response lists the roles that are created, updated with the
+	fields that change, and deleted.
+
+	With dry_run=true only the diff is returned. Roles missing from the set are
+	left alone unless prune=true. Pruning a role that still has outstanding
+	tokens fails the whole sync unless force=true, in which case the tokens stay
+	valid until their leases expire.
+
+	Other role writes, deletes, imports and restores wait while a sync applies,
+	so no other change to roles is interleaved with it. If a change cannot be
+	stored, the changes already made are undone on a best effort basis; an undo
+	that fails leaves the roles partially synced, and the error names the roles
+	that could not be restored.
+	`
+
+	pathRolesExportDef             = "roles-export"
+	pathRolesExportHelpSynopsis    = "Export every role in the format accepted by roles-sync."
+	pathRolesExportHelpDescription = `
+	This path returns every role as a map of role name to role fields, ready to
+	be written back to roles-sync.
+	`
+)
+
+var roleNameRegex = regexp.MustCompile("^" + framework.GenericNameRegex("name") + "$")
+
+// roleChange is a change made to a role by a sync, with the entry it
+// replaced so the change can be undone
+type roleChange struct {
+	name     string
+	previous *RollbarRoleEntry
+	desired  *RollbarRoleEntry
+}
+
+func pathRolesSync(b *RollbarBackend) []*framework.Path {
+
+	return []*framework.Path{
+		{
+			Pattern: pathRolesSyncDef,
+			Fields: map[string]*framework.FieldSchema{
+				"roles": {
+					Type:        framework.TypeMap,
+					Description: "Required. Desired roles as a map of role name to role fields",
+					Required:    true,
+				},
+				"dry_run": {
+					Type:        framework.TypeBool,
+					Description: "Optional. Only return the diff without applying it.",
+				},
+				"prune": {
+					Type:        framework.TypeBool,
+					Description: "Optional. Delete the roles missing from the set.",
+				},
+				"force": {
+					Type:        framework.TypeBool,
+					Description: "Optional. Prune roles even if tokens issued from them are still outstanding.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathRolesSyncWrite,
+				},
+			},
+			HelpSynopsis:    pathRolesSyncHelpSynopsis,
+			HelpDescription: pathRolesSyncHelpDescription,
+		},
+		{
+			Pattern: pathRolesExportDef,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathRolesExportRead,
+				},
+			},
+			HelpSynopsis:    pathRolesExportHelpSynopsis,
+			HelpDescription: pathRolesExportHelpDescription,
+		},
+	}
+}
+
+// pathRolesExportRead returns every role in the roles-sync format
+func (b *RollbarBackend) pathRolesExportRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	names, err := req.Storage.List(ctx, pathRoleDef)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		roleEntry, err := b.getRole(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if roleEntry == nil {
+			continue
+		}
+		roles[name] = roleEntry.toResponseData()
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"roles": roles,
+		},
+	}, nil
+}
+
+// pathRolesSyncWrite validates a desired set of roles, returns the diff to
+// the current roles and applies it unless dry_run is set
+func (b *RollbarBackend) pathRolesSyncWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	b.syncLock.Lock()
+	defer b.syncLock.Unlock()
+
+	desired, errs := parseRoleSet(d.Get("roles").(map[string]interface{}))
+	if len(errs) > 0 {
+		resp := logical.ErrorResponse("invalid role definitions, nothing was changed")
+		resp.Data["errors"] = errs
+		return resp, nil
+	}
+
+	names, err := req.Storage.List(ctx, pathRoleDef)
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]*RollbarRoleEntry, len(names))
+	for _, name := range names {
+		roleEntry, err := b.getRole(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if roleEntry != nil {
+			current[name] = roleEntry
+		}
+	}
+
+	var changes []roleChange
+	created := []string{}
+	updated := map[string]interface{}{}
+	for name, roleEntry := range desired {
+		previous, ok := current[name]
+		switch {
+		case !ok:
+			created = append(created, name)
+		case !reflect.DeepEqual(previous, roleEntry):
+			updated[name] = roleDiff(previous, roleEntry)
+		default:
+			continue
+		}
+		changes = append(changes, roleChange{name: name, previous: previous, desired: roleEntry})
+	}
+
+	deleted := []string{}
+	if d.Get("prune").(bool) {
+		outstanding, err := outstandingByRole(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		blocked := map[string]string{}
+		for name, previous := range current {
+			if _, ok := desired[name]; ok {
+				continue
+			}
+			if outstanding[name] > 0 && !d.Get("force").(bool) {
+				blocked[name] = fmt.Sprintf("role has %d outstanding tokens", outstanding[name])
+				continue
+			}
+			deleted = append(deleted, name)
+			changes = append(changes, roleChange{name: name, previous: previous})
+		}
+
+		if len(blocked) > 0 {
+			resp := logical.ErrorResponse("cannot prune roles with outstanding tokens without force=true, nothing was changed")
+			resp.Data["errors"] = blocked
+			return resp, nil
+		}
+	}
+
+	sort.Strings(created)
+	sort.Strings(deleted)
+
+	dryRun := d.Get("dry_run").(bool)
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"dry_run": dryRun,
+			"create":  created,
+			"update":  updated,
+			"delete":  deleted,
+		},
+	}
+
+	if dryRun {
+		return resp, nil
+	}
+
+	if err := b.applyRoleChanges(ctx, req, changes); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// applyRoleChanges stores the changes of a sync, undoing the ones already
+// stored when one fails. The undo is best effort: roles it cannot restore
+// are named in the returned error.
+func (b *RollbarBackend) applyRoleChanges(ctx context.Context, req *logical.Request, changes []roleChange) error {
+	for i, change := range changes {
+		if err := b.storeRoleChange(ctx, req, change.name, change.desired); err != nil {
+			var partial []string
+			for _, applied := range changes[:i] {
+				if undoErr := b.storeRoleChange(ctx, req, applied.name, applied.previous); undoErr != nil {
+					b.Logger().Error("error undoing role change after failed sync", "role", applied.name, "error", undoErr)
+					partial = append(partial, applied.name)
+				}
+			}
+			if len(partial) > 0 {
+				return fmt.Errorf("error syncing role %q, roles %s could not be restored and are left synced: %w", change.name, strings.Join(partial, ", "), err)
+			}
+			return fmt.Errorf("error syncing role %q, changes were undone: %w", change.name, err)
+		}
+	}
+
+	return nil
+}
+
+// storeRoleChange stores a role entry, or deletes the role when it is nil,
+// and records the new version
+func (b *RollbarBackend) storeRoleChange(ctx context.Context, req *logical.Request, name string, roleEntry *RollbarRoleEntry) error {
+	if roleEntry == nil {
+		if err := req.Storage.Delete(ctx, pathRoleDef+name); err != nil {
+			return err
+		}
+		return b.recordVersion(ctx, req, versionsRoleKey(name), nil)
+	}
+
+	if err := setRole(ctx, req.Storage, name, roleEntry); err != nil {
+		return err
+	}
+
+	return b.recordVersion(ctx, req, versionsRoleKey(name), roleEntry)
+}
+
+// parseRoleSet builds and validates the role entries of a desired role set,
+// returning the validation errors by role name
+func parseRoleSet(raw map[string]interface{}) (map[string]*RollbarRoleEntry, map[string]string) {
+	roles := make(map[string]*RollbarRoleEntry, len(raw))
+	errs := map[string]string{}
+	for name, def := range raw {
+		fields, ok := def.(map[string]interface{})
+		if !ok {
+			errs[name] = "role definition must be a map of role fields"
+			continue
+		}
+
+		roleEntry, err := newRoleEntry(name, fields)
+		if err != nil {
+			errs[name] = err.Error()
+			continue
+		}
+		roles[name] = roleEntry
+	}
+
+	return roles, errs
+}
+
+// newRoleEntry builds and validates a new role from a map of role fields,
+// with the same defaults and checks as creating it through roles/<name>
+func newRoleEntry(name string, fields map[string]interface{}) (*RollbarRoleEntry, error) {
+	if !roleNameRegex.MatchString(name) || name != strings.ToLower(name) {
+		return nil, errors.New("invalid role name")
+	}
+	if reservedRoleName(name) {
+		return nil, errors.New("reserved role name")
+	}
+
+	schema := roleFields()
+	// these fields name the role or only apply to deleting it
+	delete(schema, "name")
+	delete(schema, "revoke_outstanding")
+	delete(schema, "force")
+
+	if err := checkRoleFieldNames(fields, schema); err != nil {
+		return nil, err
+	}
+
+	d := &framework.FieldData{
+		Raw:    fields,
+		Schema: schema,
+	}
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	roleEntry := &RollbarRoleEntry{
+		Name:          name,
+		RoleType:      roleTypeProject,
+		TTL:           defaultTTL,
+		MaxTTL:        defaultMaxTTL,
+		SchemaVersion: roleSchemaVersion,
+	}
+	if resp := applyRoleFields(roleEntry, d, true); resp != nil {
+		return nil, resp.Error()
+	}
+
+	return roleEntry, nil
+}
+
+// checkRoleFieldNames returns an error naming the first field of a role
+// definition that is not a role field
+func checkRoleFieldNames(fields map[string]interface{}, schema map[string]*framework.FieldSchema) error {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, ok := schema[name]; !ok {
+			return fmt.Errorf("unknown field %q", name)
+		}
+	}
+
+	return nil
+}
+
+// roleDiff returns the fields that differ between two versions of a role
+func roleDiff(previous, desired *RollbarRoleEntry) map[string]interface{} {
+	from := previous.toResponseData()
+	to := desired.toResponseData()
+
+	diff := map[string]interface{}{}
+	for field, value := range to {
+		if !reflect.DeepEqual(from[field], value) {
+			diff[field] = map[string]interface{}{
+				"from": from[field],
+				"to":   value,
+			}
+		}
+	}
+
+	return diff
+}
+
+// outstandingByRole returns the number of outstanding credentials per role
+func outstandingByRole(ctx context.Context, s logical.Storage) (map[string]int, error) {
+	creds, err := listCredentials(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for _, cred := range creds {
+		counts[cred.Role]++
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,142 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestRolesExportSyncRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	b, s := newTestBackend(t, nil)
+
+	roles := map[string]map[string]interface{}{
+		"minimal": {
+			"project_id": 1,
+		},
+		"web": {
+			"project_id":                  1,
+			"project_access_token_scopes": "read,write",
+			"ttl":                         "30m",
+			"max_ttl":                     "1h",
+			"max_batch_size":              10,
+			"pool_size":                   2,
+			"pool_max_age":                "15m",
+			"max_active_tokens":           5,
+			"description":                 "Error reporting for the web app",
+			"tags":                        "web,prod",
+			"owner":                       "team-web",
+			"metadata":                    map[string]interface{}{"tier": "1"},
+		},
+		"reuse": {
+			"project_id":                   2,
+			"reuse_for_entity":             true,
+			"reuse_min_remaining":          0.25,
+			"max_tokens_per_entity":        3,
+			"max_issuance_rate_per_entity": 10,
+			"issuance_rate_window":         "1h",
+		},
+		"ci": {
+			"project_id":        3,
+			"activate_on_claim": true,
+			"claim_window":      "10m",
+		},
+		"reporting": {
+			"role_type":                   roleTypeAccount,
+			"account_access_token_scopes": "read",
+			"ttl":                         0,
+		},
+	}
+	for name, data := range roles {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "roles/" + name,
+			Storage:   s,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("writing role %q: %v %v", name, resp, err)
+		}
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "roles-export",
+		Storage:   s,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("exporting roles: %v %v", resp, err)
+	}
+
+	// the export reaches roles-sync as JSON through the CLI or API
+	raw, err := json.Marshal(resp.Data["roles"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &exported); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles-sync",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"roles":   exported,
+			"dry_run": true,
+			"prune":   true,
+		},
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("syncing roles: %v %v", resp, err)
+	}
+
+	if created := resp.Data["create"].([]string); len(created) != 0 {
+		t.Errorf("sync of the export creates %v", created)
+	}
+	if updated := resp.Data["update"].(map[string]interface{}); len(updated) != 0 {
+		t.Errorf("sync of the export updates %v", updated)
+	}
+	if deleted := resp.Data["delete"].([]string); len(deleted) != 0 {
+		t.Errorf("sync of the export deletes %v", deleted)
+	}
+}
+
+func TestRoleWriteWaitsForSync(t *testing.T) {
+	ctx := context.Background()
+	b, s := newTestBackend(t, nil)
+
+	// a sync in progress
+	b.syncLock.Lock()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "roles/web",
+			Storage:   s,
+			Data:      map[string]interface{}{"project_id": 1},
+		})
+		done <- err
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("role written while a sync was applying")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	b.syncLock.Unlock()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	roleEntry, err := b.getRole(ctx, s, "web")
+	if err != nil || roleEntry == nil {
+		t.Fatalf("reading role after sync: %v %v", roleEntry, err)
+	}
+}
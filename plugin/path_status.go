@@ -0,0 +1,124 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	pathStatusDef             = "status"
+	pathStatusHelpSynopsis    = "Report the health of the rollbar backend."
+	pathStatusHelpDescription = `
+	This path reports whether the backend is configured, a fingerprint of its
+	configuration, the running plugin version, the outcome of the last rollbar
+	API calls, the circuit breaker and rate limit state, and the number of roles
+	and live credentials. Set probe=true to also check that rollbar is reachable.
+
+	No secret is returned, so the path can be granted to monitoring policies.
+	`
+)
+
+func pathStatus(b *RollbarBackend) *framework.Path {
+
+	return &framework.Path{
+		Pattern: pathStatusDef,
+		Fields: map[string]*framework.FieldSchema{
+			"probe": {
+				Type:        framework.TypeBool,
+				Description: "Optional. Make a live call to rollbar to check connectivity.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathStatusRead,
+			},
+		},
+		HelpSynopsis:    pathStatusHelpSynopsis,
+		HelpDescription: pathStatusHelpDescription,
+	}
+}
+
+// pathStatusRead returns health and diagnostic information about the backend
+func (b *RollbarBackend) pathStatusRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	config, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := req.Storage.List(ctx, pathRoleDef)
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := req.Storage.List(ctx, credsStoragePath)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"configured":                  config != nil,
+		"config_fingerprint":          "",
+		"version":                     Version,
+		"roles":                       len(roles),
+		"live_credentials":            len(creds),
+		"breaker":                     nil,
+		"active_account_access_token": "",
+		"probe":                       nil,
+	}
+	for k, v := range b.stats.toResponseData() {
+		data[k] = v
+	}
+
+	if config == nil {
+		return &logical.Response{Data: data}, nil
+	}
+
+	data["config_fingerprint"], err = configFingerprint(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	data["breaker"] = client.breaker.snapshot().toResponseData()
+	data["active_account_access_token"] = client.activeCredential()
+
+	if d.Get("probe").(bool) {
+		start := time.Now()
+		probe := map[string]interface{}{
+			"reachable": true,
+		}
+		if err := client.Ping(ctx); err != nil {
+			probe["reachable"] = false
+			probe["error"] = err.Error()
+		}
+		probe["duration_ms"] = time.Since(start).Milliseconds()
+		data["probe"] = probe
+	}
+
+	return &logical.Response{Data: data}, nil
+}
+
+// configFingerprint returns a short digest of the stored configuration that
+// changes whenever it does without revealing the account access token
+func configFingerprint(ctx context.Context, s logical.Storage) (string, error) {
+	entry, err := s.Get(ctx, configStoragePath)
+	if err != nil {
+		return "", err
+	}
+
+	if entry == nil {
+		return "", nil
+	}
+
+	sum := sha256.Sum256(entry.Value)
+	return hex.EncodeToString(sum[:8]), nil
+}
@@ -0,0 +1,427 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	versionsStoragePath    = "versions/"
+	versionsConfigKey      = "config"
+	defaultHistoryVersions = 10
+	redactedValue          = "<redacted>"
+
+	pathVersionsHelpSynopsis    = "Read and restore past versions of the config and of roles."
+	pathVersionsHelpDescription = `
+	The backend keeps the last versions of its config and of each role, with the
+	entity that made each change and when. List versions/config/ or
+	versions/roles/<name>/ to see them, read a version to see its content, and
+	write to its restore path to make it current again. Secret fields such as the
+	account access token are redacted when reading a version.
+
+	The number of versions kept is set with history_versions on the config.
+	`
+)
+
+var (
+	redactedConfigFields = []string{
+		"account_access_token",
+		"secondary_account_access_token",
+	}
+)
+
+// versionEntry is a past version of a config or role storage entry
+type versionEntry struct {
+	Version           int             `json:"version"`
+	Deleted           bool            `json:"deleted"`
+	Data              json.RawMessage `json:"data,omitempty"`
+	EntityID          string          `json:"entity_id"`
+	EntityDisplayName string          `json:"entity_display_name"`
+	ChangedAt         time.Time       `json:"changed_at"`
+	RestoredFrom      int             `json:"restored_from,omitempty"`
+}
+
+// versionsRoleKey returns the history key of a role
+func versionsRoleKey(name string) string {
+	return "roles/" + name
+}
+
+func pathVersions(b *RollbarBackend) []*framework.Path {
+
+	configKey := func(d *framework.FieldData) string { return versionsConfigKey }
+	roleKey := func(d *framework.FieldData) string { return versionsRoleKey(d.Get("name").(string)) }
+
+	roleFields := map[string]*framework.FieldSchema{
+		"name": {
+			Type:        framework.TypeLowerCaseString,
+			Description: "Required. Name of the role",
+			Required:    true,
+		},
+		"version": {
+			Type:        framework.TypeInt,
+			Description: "Required. Version number",
+		},
+	}
+	configFields := map[string]*framework.FieldSchema{
+		"version": {
+			Type:        framework.TypeInt,
+			Description: "Required. Version number",
+		},
+	}
+
+	return []*framework.Path{
+		{
+			Pattern: versionsStoragePath + versionsConfigKey + "/?$",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathVersionsList(configKey),
+				},
+			},
+			HelpSynopsis:    pathVersionsHelpSynopsis,
+			HelpDescription: pathVersionsHelpDescription,
+		},
+		{
+			Pattern: versionsStoragePath + versionsConfigKey + "/" + `(?P<version>\d+)$`,
+			Fields:  configFields,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathVersionsRead(configKey),
+				},
+			},
+			HelpSynopsis:    pathVersionsHelpSynopsis,
+			HelpDescription: pathVersionsHelpDescription,
+		},
+		{
+			Pattern: versionsStoragePath + versionsConfigKey + "/" + `(?P<version>\d+)/restore$`,
+			Fields:  configFields,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathVersionsRestoreConfig,
+				},
+			},
+			HelpSynopsis:    pathVersionsHelpSynopsis,
+			HelpDescription: pathVersionsHelpDescription,
+		},
+		{
+			Pattern: versionsStoragePath + "roles/" + framework.GenericNameRegex("name") + "/?$",
+			Fields:  roleFields,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathVersionsList(roleKey),
+				},
+			},
+			HelpSynopsis:    pathVersionsHelpSynopsis,
+			HelpDescription: pathVersionsHelpDescription,
+		},
+		{
+			Pattern: versionsStoragePath + "roles/" + framework.GenericNameRegex("name") + `/(?P<version>\d+)$`,
+			Fields:  roleFields,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathVersionsRead(roleKey),
+				},
+			},
+			HelpSynopsis:    pathVersionsHelpSynopsis,
+			HelpDescription: pathVersionsHelpDescription,
+		},
+		{
+			Pattern: versionsStoragePath + "roles/" + framework.GenericNameRegex("name") + `/(?P<version>\d+)/restore$`,
+			Fields:  roleFields,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathVersionsRestoreRole,
+				},
+			},
+			HelpSynopsis:    pathVersionsHelpSynopsis,
+			HelpDescription: pathVersionsHelpDescription,
+		},
+	}
+}
+
+// pathVersionsList lists the versions kept for a storage entry
+func (b *RollbarBackend) pathVersionsList(key func(*framework.FieldData) string) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+		versions, err := listVersions(ctx, req.Storage, key(d))
+		if err != nil {
+			return nil, err
+		}
+
+		keys := make([]string, 0, len(versions))
+		keyInfo := map[string]interface{}{}
+		for _, v := range versions {
+			k := strconv.Itoa(v.Version)
+			keys = append(keys, k)
+			keyInfo[k] = map[string]interface{}{
+				"deleted":             v.Deleted,
+				"entity_id":           v.EntityID,
+				"entity_display_name": v.EntityDisplayName,
+				"changed_at":          v.ChangedAt,
+			}
+		}
+
+		return logical.ListResponseWithInfo(keys, keyInfo), nil
+	}
+}
+
+// pathVersionsRead returns a past version of a storage entry, with its
+// secret fields redacted
+func (b *RollbarBackend) pathVersionsRead(key func(*framework.FieldData) string) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+		k := key(d)
+		v, err := getVersion(ctx, req.Storage, k, d.Get("version").(int))
+		if err != nil {
+			return nil, err
+		}
+
+		if v == nil {
+			return nil, nil
+		}
+
+		data, err := v.toResponseData(k == versionsConfigKey)
+		if err != nil {
+			return nil, err
+		}
+
+		return &logical.Response{
+			Data: data,
+		}, nil
+	}
+}
+
+// pathVersionsRestoreConfig makes a past version of the config current
+func (b *RollbarBackend) pathVersionsRestoreConfig(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	version := d.Get("version").(int)
+	v, err := getVersion(ctx, req.Storage, versionsConfigKey, version)
+	if err != nil {
+		return nil, err
+	}
+
+	if v == nil {
+		return logical.ErrorResponse("config version %d not found", version), nil
+	}
+
+	if v.Deleted {
+		return logical.ErrorResponse("config version %d records a deletion and cannot be restored", version), nil
+	}
+
+	config := new(RollbarConfig)
+	if err := json.Unmarshal(v.Data, config); err != nil {
+		return nil, fmt.Errorf("error decoding config version %d: %w", version, err)
+	}
+
+	if err := setConfig(ctx, req.Storage, config); err != nil {
+		return nil, err
+	}
+
+	b.reset()
+
+	if err := b.appendVersion(ctx, req, versionsConfigKey, config, version); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// pathVersionsRestoreRole makes a past version of a role current
+func (b *RollbarBackend) pathVersionsRestoreRole(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	b.syncLock.RLock()
+	defer b.syncLock.RUnlock()
+
+	name := d.Get("name").(string)
+	version := d.Get("version").(int)
+	v, err := getVersion(ctx, req.Storage, versionsRoleKey(name), version)
+	if err != nil {
+		return nil, err
+	}
+
+	if v == nil {
+		return logical.ErrorResponse("role %q version %d not found", name, version), nil
+	}
+
+	if v.Deleted {
+		return logical.ErrorResponse("role %q version %d records a deletion and cannot be restored", name, version), nil
+	}
+
+	roleEntry := new(RollbarRoleEntry)
+	if err := json.Unmarshal(v.Data, roleEntry); err != nil {
+		return nil, fmt.Errorf("error decoding role %q version %d: %w", name, version, err)
+	}
+
+	if err := setRole(ctx, req.Storage, name, roleEntry); err != nil {
+		return nil, err
+	}
+
+	if err := b.appendVersion(ctx, req, versionsRoleKey(name), roleEntry, version); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// recordVersion appends the new value of a storage entry to its history.
+// A nil value records the deletion of the entry.
+func (b *RollbarBackend) recordVersion(ctx context.Context, req *logical.Request, key string, value interface{}) error {
+	return b.appendVersion(ctx, req, key, value, 0)
+}
+
+// appendVersion appends a version to the history of a storage entry and
+// prunes the versions beyond the configured limit
+func (b *RollbarBackend) appendVersion(ctx context.Context, req *logical.Request, key string, value interface{}, restoredFrom int) error {
+	limit := defaultHistoryVersions
+	config, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+	if config != nil && config.HistoryVersions > 0 {
+		limit = config.HistoryVersions
+	}
+
+	numbers, err := listVersionNumbers(ctx, req.Storage, key)
+	if err != nil {
+		return err
+	}
+
+	next := 1
+	if len(numbers) > 0 {
+		next = numbers[len(numbers)-1] + 1
+	}
+
+	v := &versionEntry{
+		Version:           next,
+		Deleted:           value == nil,
+		EntityID:          req.EntityID,
+		EntityDisplayName: req.DisplayName,
+		ChangedAt:         time.Now().UTC(),
+		RestoredFrom:      restoredFrom,
+	}
+	if value != nil {
+		v.Data, err = json.Marshal(value)
+		if err != nil {
+			return err
+		}
+	}
+
+	entry, err := logical.StorageEntryJSON(versionStoragePath(key, next), v)
+	if err != nil {
+		return err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return fmt.Errorf("error recording version of %s: %w", key, err)
+	}
+
+	numbers = append(numbers, next)
+	for len(numbers) > limit {
+		if err := req.Storage.Delete(ctx, versionStoragePath(key, numbers[0])); err != nil {
+			return fmt.Errorf("error pruning versions of %s: %w", key, err)
+		}
+		numbers = numbers[1:]
+	}
+
+	return nil
+}
+
+// versionStoragePath returns the storage path of a version of an entry
+func versionStoragePath(key string, version int) string {
+	return versionsStoragePath + key + "/" + strconv.Itoa(version)
+}
+
+// listVersionNumbers returns the version numbers kept for an entry in
+// ascending order
+func listVersionNumbers(ctx context.Context, s logical.Storage, key string) ([]int, error) {
+	keys, err := s.List(ctx, versionsStoragePath+key+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := make([]int, 0, len(keys))
+	for _, k := range keys {
+		n, err := strconv.Atoi(k)
+		if err != nil {
+			continue
+		}
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	return numbers, nil
+}
+
+// listVersions returns the versions kept for an entry in ascending order
+func listVersions(ctx context.Context, s logical.Storage, key string) ([]*versionEntry, error) {
+	numbers, err := listVersionNumbers(ctx, s, key)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]*versionEntry, 0, len(numbers))
+	for _, n := range numbers {
+		v, err := getVersion(ctx, s, key, n)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			versions = append(versions, v)
+		}
+	}
+
+	return versions, nil
+}
+
+// getVersion gets a version of an entry from the Vault storage API
+func getVersion(ctx context.Context, s logical.Storage, key string, version int) (*versionEntry, error) {
+	entry, err := s.Get(ctx, versionStoragePath(key, version))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	v := new(versionEntry)
+	if err := entry.DecodeJSON(v); err != nil {
+		return nil, fmt.Errorf("error reading version %d of %s: %w", version, key, err)
+	}
+
+	return v, nil
+}
+
+// toResponseData returns response data for a version, redacting the
+// secret fields of config versions
+func (v *versionEntry) toResponseData(redact bool) (map[string]interface{}, error) {
+	var data map[string]interface{}
+	if len(v.Data) > 0 {
+		if err := json.Unmarshal(v.Data, &data); err != nil {
+			return nil, err
+		}
+	}
+
+	if redact {
+		for _, field := range redactedConfigFields {
+			if s, ok := data[field].(string); ok && s != "" {
+				data[field] = redactedValue
+			}
+		}
+	}
+
+	return map[string]interface{}{
+		"version":             v.Version,
+		"deleted":             v.Deleted,
+		"data":                data,
+		"entity_id":           v.EntityID,
+		"entity_display_name": v.EntityDisplayName,
+		"changed_at":          v.ChangedAt,
+		"restored_from":       v.RestoredFrom,
+	}, nil
+}
@@ -0,0 +1,85 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestConfigVersionRestore(t *testing.T) {
+	ctx := context.Background()
+	b, s := newTestBackend(t, nil)
+
+	for i, token := range []string{"first-token", "second-token"} {
+		op := logical.CreateOperation
+		if i > 0 {
+			op = logical.UpdateOperation
+		}
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      "config",
+			Storage:   s,
+			EntityID:  "admin",
+			Data:      map[string]interface{}{"account_access_token": token},
+		})
+		if err != nil || resp.IsError() {
+			t.Fatalf("writing config: %v %v", resp, err)
+		}
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "versions/config/",
+		Storage:   s,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("listing versions: %v %v", resp, err)
+	}
+	if keys := resp.Data["keys"].([]string); len(keys) != 2 {
+		t.Fatalf("versions %v, want 2", keys)
+	}
+
+	// secrets are redacted when reading a version
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "versions/config/1",
+		Storage:   s,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("reading version: %v %v", resp, err)
+	}
+	data := resp.Data["data"].(map[string]interface{})
+	if data["account_access_token"] != redactedValue {
+		t.Errorf("version shows account_access_token %v, want it redacted", data["account_access_token"])
+	}
+	if resp.Data["entity_id"] != "admin" {
+		t.Errorf("version made by %v, want admin", resp.Data["entity_id"])
+	}
+
+	// but restoring a version brings back the real value
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "versions/config/1/restore",
+		Storage:   s,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("restoring version: %v %v", resp, err)
+	}
+
+	config, err := getConfig(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.AccountAccessToken != "first-token" {
+		t.Errorf("restored account_access_token %q, want first-token", config.AccountAccessToken)
+	}
+
+	versions, err := listVersions(ctx, s, versionsConfigKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || versions[2].RestoredFrom != 1 {
+		t.Errorf("versions after restore %+v, want a third restored from 1", versions)
+	}
+}
@@ -0,0 +1,333 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	poolStoragePath   = "pool/"
+	defaultPoolMaxAge = time.Hour
+	maxPoolSizeLimit  = 100
+)
+
+// pooledToken is a project access token created ahead of time for a role.
+// Pool entries are seal wrapped as they hold the token value.
+type pooledToken struct {
+	ID           string                    `json:"id"`
+	TokenName    string                    `json:"token_name"`
+	ProjectID    int                       `json:"project_id"`
+	Scopes       []string                  `json:"scope_list"`
+	LegacyScopes string                    `json:"scopes,omitempty"`
+	Token        rollbarProjectAccessToken `json:"token"`
+	CreatedAt    time.Time                 `json:"created_at"`
+}
+
+// fresh reports whether a pooled token may still be handed out for a role
+func (p *pooledToken) fresh(roleEntry *RollbarRoleEntry) bool {
+	maxAge := roleEntry.PoolMaxAge
+	if maxAge <= 0 {
+		maxAge = defaultPoolMaxAge
+	}
+
+	return p.ProjectID == roleEntry.ProjectID &&
+		sameScopes(p.Scopes, roleEntry.ProjectAccessTokenScopes) &&
+		time.Since(p.CreatedAt) < maxAge
+}
+
+// takePooledToken removes and returns a fresh pooled token for the role,
+// or nil when its pool is empty
+func (b *RollbarBackend) takePooledToken(ctx context.Context, s logical.Storage, roleEntry *RollbarRoleEntry) (*pooledToken, error) {
+	if roleEntry.PoolSize == 0 {
+		return nil, nil
+	}
+
+	b.poolLock.Lock()
+	defer b.poolLock.Unlock()
+
+	ids, err := s.List(ctx, poolStoragePath+roleEntry.Name+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		pooled, err := getPooledToken(ctx, s, roleEntry.Name, id)
+		if err != nil {
+			return nil, err
+		}
+		if pooled == nil || !pooled.fresh(roleEntry) {
+			// stale tokens are deleted by the next refill
+			continue
+		}
+
+		if err := s.Delete(ctx, poolStoragePath+roleEntry.Name+"/"+id); err != nil {
+			return nil, err
+		}
+
+		metrics.IncrCounterWithLabels([]string{"rollbar", "pool", "hit"}, 1, []metrics.Label{{Name: "role", Value: roleEntry.Name}})
+		return pooled, nil
+	}
+
+	metrics.IncrCounterWithLabels([]string{"rollbar", "pool", "miss"}, 1, []metrics.Label{{Name: "role", Value: roleEntry.Name}})
+	return nil, nil
+}
+
+// refillPools tops up the token pool of every role with a pool_size,
+// deletes stale pooled tokens and drains the pools of roles that no longer
+// want one
+func (b *RollbarBackend) refillPools(ctx context.Context, s logical.Storage) error {
+	roles, err := s.List(ctx, pathRoleDef)
+	if err != nil {
+		return err
+	}
+
+	pools, err := s.List(ctx, poolStoragePath)
+	if err != nil {
+		return err
+	}
+
+	names := map[string]bool{}
+	for _, name := range roles {
+		names[name] = true
+	}
+	for _, pool := range pools {
+		names[strings.TrimSuffix(pool, "/")] = true
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	config, err := getConfig(ctx, s)
+	if err != nil || config == nil {
+		return err
+	}
+
+	client, err := b.getClient(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	var merr *multierror.Error
+	for name := range names {
+		roleEntry, err := b.getRole(ctx, s, name)
+		if err != nil {
+			merr = multierror.Append(merr, err)
+			continue
+		}
+		if roleEntry == nil {
+			// keep the name so the orphaned pool is drained
+			roleEntry = &RollbarRoleEntry{Name: name}
+		}
+
+		if err := b.refillPool(ctx, s, client, roleEntry); err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("error refilling token pool of role %q: %w", name, err))
+		}
+	}
+
+	return merr.ErrorOrNil()
+}
+
+// refillPool brings the token pool of a role back to its pool_size, or as
+// close as max_active_tokens allows. Room for the new tokens is reserved
+// under the role's issuance lock, which is released before they are
+// created, so issuance is never blocked behind a slow rollbar call. The
+// pool of a blocked project is drained.
+func (b *RollbarBackend) refillPool(ctx context.Context, s logical.Storage, client *rollbarClient, roleEntry *RollbarRoleEntry) error {
+	wanted := roleEntry.PoolSize
+	if roleEntry.RoleType != roleTypeProject {
+		wanted = 0
+	}
+
+	if wanted > 0 {
+		block, err := getProjectBlock(ctx, s, roleEntry.ProjectID)
+		if err != nil {
+			return err
+		}
+		if block != nil {
+			wanted = 0
+		}
+	}
+
+	depth, err := b.prunePool(ctx, s, client, roleEntry, wanted)
+	if err != nil {
+		return err
+	}
+
+	if depth >= wanted {
+		b.emitPoolDepth(roleEntry.Name, depth)
+		return nil
+	}
+
+	reservations, _, err := b.reserveTokens(ctx, s, roleEntry, roleEntry.Name+"-", wanted-depth, true)
+	if err != nil {
+		return err
+	}
+
+	prefix := poolStoragePath + roleEntry.Name + "/"
+	for i, r := range reservations {
+		token, err := createProjectAccessToken(ctx, client, roleEntry.ProjectAccessTokenScopes, roleEntry.ProjectID, r.TokenName)
+		if err == nil && (token == nil || token.AccessToken == "") {
+			b.releaseUnconfirmed(ctx, s, r)
+			err = errors.New("rollbar returned no token")
+		} else if err != nil {
+			b.releaseFailedCreate(ctx, s, err, r)
+		}
+		if err != nil {
+			b.releaseReservations(ctx, s, reservations[i+1:]...)
+			b.emitPoolDepth(roleEntry.Name, depth)
+			return err
+		}
+
+		pooled := &pooledToken{
+			ID:        r.CredentialID,
+			TokenName: r.TokenName,
+			ProjectID: roleEntry.ProjectID,
+			Scopes:    roleEntry.scopes(),
+			Token:     *token,
+			CreatedAt: time.Now().UTC(),
+		}
+		entry, err := logical.StorageEntryJSON(prefix+r.CredentialID, pooled)
+		if err == nil {
+			err = s.Put(ctx, entry)
+		}
+		if err != nil {
+			if delErr := deleteProjectAccessToken(ctx, client, roleEntry.ProjectID, token.AccessToken); delErr != nil {
+				b.Logger().Error("error deleting unpooled project access token", "name", r.TokenName, "error", delErr)
+			} else {
+				b.releaseReservations(ctx, s, r)
+			}
+			b.releaseReservations(ctx, s, reservations[i+1:]...)
+			b.emitPoolDepth(roleEntry.Name, depth)
+			return err
+		}
+		b.commitReservations(ctx, s, r)
+		depth++
+	}
+
+	b.emitPoolDepth(roleEntry.Name, depth)
+	return nil
+}
+
+// prunePool deletes the stale pooled tokens of a role, and any beyond the
+// wanted pool size, returning the number of tokens left in the pool. The
+// tokens are taken out of the pool under the pool lock and deleted in
+// rollbar after it is released. A token that cannot be deleted is left to
+// the WAL rollback.
+func (b *RollbarBackend) prunePool(ctx context.Context, s logical.Storage, client *rollbarClient, roleEntry *RollbarRoleEntry, wanted int) (int, error) {
+	depth, stale, err := b.takeStalePooledTokens(ctx, s, roleEntry, wanted)
+
+	var merr *multierror.Error
+	if err != nil {
+		merr = multierror.Append(merr, err)
+	}
+
+	for _, r := range stale {
+		err := deleteProjectAccessToken(ctx, client, r.ProjectID, r.token)
+		if err != nil && !errors.Is(err, errRollbarNotFound) {
+			merr = multierror.Append(merr, fmt.Errorf("error deleting stale pooled token %q: %w", r.TokenName, err))
+			continue
+		}
+		if err := framework.DeleteWAL(ctx, s, r.walID); err != nil {
+			b.Logger().Warn("error removing WAL entry of deleted pooled token", "name", r.TokenName, "error", err)
+		}
+	}
+
+	return depth, merr.ErrorOrNil()
+}
+
+// stalePooledToken is a pooled token taken out of its pool to be deleted
+type stalePooledToken struct {
+	*tokenReservation
+	token string
+}
+
+// takeStalePooledTokens removes the stale pooled tokens of a role, and any
+// beyond the wanted pool size, from storage. Each is covered by a WAL entry
+// until it is deleted in rollbar. It returns the number of tokens left in
+// the pool and the tokens removed.
+func (b *RollbarBackend) takeStalePooledTokens(ctx context.Context, s logical.Storage, roleEntry *RollbarRoleEntry, wanted int) (int, []stalePooledToken, error) {
+	b.poolLock.Lock()
+	defer b.poolLock.Unlock()
+
+	prefix := poolStoragePath + roleEntry.Name + "/"
+	ids, err := s.List(ctx, prefix)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	depth := 0
+	stale := []stalePooledToken{}
+	for _, id := range ids {
+		pooled, err := getPooledToken(ctx, s, roleEntry.Name, id)
+		if err != nil {
+			return depth, stale, err
+		}
+		if pooled != nil && pooled.fresh(roleEntry) && depth < wanted {
+			depth++
+			continue
+		}
+
+		if pooled == nil {
+			if err := s.Delete(ctx, prefix+id); err != nil {
+				return depth, stale, err
+			}
+			continue
+		}
+
+		r := &tokenReservation{
+			Role:         roleEntry.Name,
+			Type:         roleTypeProject,
+			ProjectID:    pooled.ProjectID,
+			TokenName:    pooled.TokenName,
+			CredentialID: pooled.ID,
+			Pooled:       true,
+		}
+		r.walID, err = framework.PutWAL(ctx, s, walKindToken, r)
+		if err != nil {
+			return depth, stale, fmt.Errorf("error writing WAL entry of stale pooled token: %w", err)
+		}
+		if err := s.Delete(ctx, prefix+id); err != nil {
+			if walErr := framework.DeleteWAL(ctx, s, r.walID); walErr != nil {
+				b.Logger().Warn("error removing WAL entry of stale pooled token", "name", r.TokenName, "error", walErr)
+			}
+			return depth, stale, err
+		}
+		stale = append(stale, stalePooledToken{tokenReservation: r, token: pooled.Token.AccessToken})
+	}
+
+	return depth, stale, nil
+}
+
+// emitPoolDepth reports the number of tokens pooled for a role
+func (b *RollbarBackend) emitPoolDepth(role string, depth int) {
+	metrics.SetGaugeWithLabels([]string{"rollbar", "pool", "depth"}, float32(depth), []metrics.Label{{Name: "role", Value: role}})
+}
+
+// getPooledToken gets a pooled token from the Vault storage API
+func getPooledToken(ctx context.Context, s logical.Storage, role, id string) (*pooledToken, error) {
+	entry, err := s.Get(ctx, poolStoragePath+role+"/"+id)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	pooled := new(pooledToken)
+	if err := entry.DecodeJSON(pooled); err != nil {
+		return nil, fmt.Errorf("error reading pooled token %q: %w", id, err)
+	}
+	upgradeScopes(&pooled.Scopes, &pooled.LegacyScopes)
+
+	return pooled, nil
+}
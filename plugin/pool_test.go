@@ -0,0 +1,148 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// poolDepth returns the number of tokens pooled for a role
+func poolDepth(t *testing.T, s logical.Storage, role string) int {
+	t.Helper()
+
+	ids, err := s.List(context.Background(), poolStoragePath+role+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(ids)
+}
+
+func TestPoolHitMissAndRefill(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeProjectTokens()
+	_, config := newFakeRollbar(t, fake.ServeHTTP)
+	b, s := newTestBackend(t, config)
+
+	role := &RollbarRoleEntry{
+		Name:                     "test",
+		RoleType:                 roleTypeProject,
+		ProjectID:                1,
+		ProjectAccessTokenScopes: []string{"read"},
+		PoolSize:                 2,
+	}
+	if err := setRole(ctx, s, role.Name, role); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.refillPools(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if depth := poolDepth(t, s, "test"); depth != 2 || fake.creates != 2 {
+		t.Fatalf("refill pooled %d tokens with %d creates, want 2", depth, fake.creates)
+	}
+
+	// both issuances are served from the pool
+	for i := 0; i < 2; i++ {
+		resp, err := b.HandleRequest(ctx, issueRequest(s, ""))
+		if err != nil || resp.IsError() {
+			t.Fatalf("issuing token: %v %v", resp, err)
+		}
+		if _, ok := fake.status(resp.Data["token_name"].(string)); !ok {
+			t.Errorf("issued token %v is not in rollbar", resp.Data["token_name"])
+		}
+	}
+	if depth := poolDepth(t, s, "test"); depth != 0 || fake.creates != 2 {
+		t.Fatalf("after pool hits %d tokens pooled and %d creates, want 0 and 2", depth, fake.creates)
+	}
+
+	// an empty pool falls back to creating the token
+	resp, err := b.HandleRequest(ctx, issueRequest(s, ""))
+	if err != nil || resp.IsError() {
+		t.Fatalf("issuing token: %v %v", resp, err)
+	}
+	if fake.creates != 3 {
+		t.Errorf("pool miss made %d creates, want 3", fake.creates)
+	}
+
+	if err := b.refillPools(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if depth := poolDepth(t, s, "test"); depth != 2 {
+		t.Errorf("refill left %d tokens pooled, want 2", depth)
+	}
+}
+
+func TestPrunePoolDeletesOutsidePoolLock(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeProjectTokens()
+	var b *RollbarBackend
+	deletes := 0
+	_, config := newFakeRollbar(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			deletes++
+			if !b.poolLock.TryLock() {
+				t.Error("pool lock held while deleting in rollbar")
+			} else {
+				b.poolLock.Unlock()
+			}
+			// the second stale token cannot be deleted
+			if deletes == 2 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		fake.ServeHTTP(w, r)
+	})
+	b, s := newTestBackend(t, config)
+
+	role := &RollbarRoleEntry{
+		Name:                     "test",
+		RoleType:                 roleTypeProject,
+		ProjectID:                1,
+		ProjectAccessTokenScopes: []string{"read"},
+		PoolSize:                 2,
+	}
+	if err := setRole(ctx, s, role.Name, role); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.refillPools(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := b.getClient(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a scope change makes the pooled tokens stale
+	role.ProjectAccessTokenScopes = []string{"write"}
+	if _, err := b.prunePool(ctx, s, client, role, role.PoolSize); err == nil {
+		t.Fatal("expected the failed delete to be reported")
+	}
+
+	if depth := poolDepth(t, s, "test"); depth != 0 {
+		t.Errorf("%d stale tokens left in the pool", depth)
+	}
+
+	// the token that could not be deleted is left to the WAL rollback
+	wals, err := framework.ListWAL(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wals) != 1 {
+		t.Fatalf("%d WAL entries after pruning, want 1", len(wals))
+	}
+	entry, err := framework.GetWAL(ctx, s, wals[0])
+	if err != nil || entry == nil {
+		t.Fatalf("reading WAL entry: %v %v", entry, err)
+	}
+	if err := b.walRollback(ctx, &logical.Request{Storage: s}, entry.Kind, entry.Data); err != nil {
+		t.Fatal(err)
+	}
+	if names := fake.names(); len(names) != 0 {
+		t.Errorf("stale tokens left in rollbar: %v", names)
+	}
+}
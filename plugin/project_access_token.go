@@ -2,8 +2,11 @@ package plugin
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -21,10 +24,57 @@ func (b *RollbarBackend) rollbarProjectAccessToken() *framework.Secret {
 			"project_access_token": {
 				Type:        framework.TypeString,
 				Description: "Rollbar Project Access Token",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+			"project_id": {
+				Type:        framework.TypeInt,
+				Description: "Rollbar project ID the token was issued for",
+			},
+			"project_name": {
+				Type:        framework.TypeString,
+				Description: "Name of the rollbar project the token was issued for",
+			},
+			"scopes": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Scopes granted to the token",
+			},
+			"token_name": {
+				Type:        framework.TypeString,
+				Description: "Name of the token in rollbar",
+			},
+			"rate_limit": {
+				Type:        framework.TypeMap,
+				Description: "Rate limit window size and count of the token",
+			},
+			"issued_at": {
+				Type:        framework.TypeTime,
+				Description: "Time the token was issued",
+			},
+			"expires_at": {
+				Type:        framework.TypeTime,
+				Description: "Time the token's lease expires unless renewed",
+			},
+			"description": {
+				Type:        framework.TypeString,
+				Description: "Description of the role the token was issued from",
+			},
+			"owner": {
+				Type:        framework.TypeString,
+				Description: "Owner of the role the token was issued from",
+			},
+			"metadata": {
+				Type:        framework.TypeKVPairs,
+				Description: "Metadata of the role the token was issued from",
+			},
+			"reused": {
+				Type:        framework.TypeBool,
+				Description: "Whether the token was handed out earlier to the same entity",
 			},
 		},
-		Renew:  b.projectAccessTokenRenew,
-		Revoke: b.projectAccessTokenRevoke,
+		Renew:  b.withSecretHistory(eventRenew, b.projectAccessTokenRenew),
+		Revoke: b.withSecretHistory(eventRevoke, b.projectAccessTokenRevoke),
 	}
 }
 
@@ -45,6 +95,15 @@ func (b *RollbarBackend) projectAccessTokenRenew(ctx context.Context, req *logic
 		return nil, errors.New("error retrieving role: role is nil")
 	}
 
+	projectID, err := b.secretProjectID(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.checkRenewable(ctx, req, projectID); err != nil {
+		return nil, err
+	}
+
 	resp := &logical.Response{Secret: req.Secret}
 	if roleEntry.TTL > 0 {
 		resp.Secret.TTL = roleEntry.TTL
@@ -53,9 +112,102 @@ func (b *RollbarBackend) projectAccessTokenRenew(ctx context.Context, req *logic
 		resp.Secret.MaxTTL = roleEntry.MaxTTL
 	}
 
+	if err := b.refreshCredential(ctx, req, resp.Secret.TTL); err != nil {
+		return nil, err
+	}
+
 	return resp, nil
 }
 
+// checkRenewable refuses to renew a token rollbar no longer knows or has
+// disabled
+func (b *RollbarBackend) checkRenewable(ctx context.Context, req *logical.Request, projectID int) error {
+	pat, _ := req.Secret.InternalData["project_access_token"].(string)
+	if pat == "" {
+		return nil
+	}
+
+	id, _ := req.Secret.InternalData["credential_id"].(string)
+
+	return b.checkTokensRenewable(ctx, req, projectID, []batchToken{{CredentialID: id, ProjectAccessToken: pat}})
+}
+
+// checkTokensRenewable refuses to renew a secret when any of its tokens
+// is no longer known to rollbar or has been disabled. When rollbar cannot
+// be reached the drift recorded by the last reconciliation is used instead.
+func (b *RollbarBackend) checkTokensRenewable(ctx context.Context, req *logical.Request, projectID int, tokens []batchToken) error {
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return fmt.Errorf("error getting client: %w", err)
+	}
+
+	pats := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		pats = append(pats, token.ProjectAccessToken)
+	}
+
+	drifts, verifyErr := verifyProjectAccessTokens(ctx, client, projectID, pats)
+	if verifyErr != nil {
+		b.Logger().Warn("unable to verify project access token with rollbar, using last reconciliation", "error", verifyErr)
+	}
+
+	for _, token := range tokens {
+		var cred *RollbarCredentialEntry
+		if token.CredentialID != "" {
+			cred, err = getCredential(ctx, req.Storage, token.CredentialID)
+			if err != nil {
+				return fmt.Errorf("error retrieving credential: %w", err)
+			}
+		}
+
+		drift := drifts[token.ProjectAccessToken]
+		if verifyErr != nil {
+			if cred == nil {
+				continue
+			}
+			drift = cred.Drift
+		}
+
+		// a token waiting to be claimed is disabled until then
+		if drift == driftDisabled && req.Secret.InternalData["claim_key"] != nil && cred != nil && cred.PendingClaim {
+			continue
+		}
+
+		switch {
+		case drift == driftMissing && len(tokens) == 1:
+			return errors.New("project access token no longer exists in rollbar")
+		case drift == driftDisabled && len(tokens) == 1:
+			return errors.New("project access token has been disabled in rollbar")
+		case drift == driftMissing:
+			return fmt.Errorf("project access token %q of the batch no longer exists in rollbar", token.CredentialID)
+		case drift == driftDisabled:
+			return fmt.Errorf("project access token %q of the batch has been disabled in rollbar", token.CredentialID)
+		}
+	}
+
+	return nil
+}
+
+// refreshCredential records the lease ID and new expiry of a renewed
+// secret in its inventory record
+func (b *RollbarBackend) refreshCredential(ctx context.Context, req *logical.Request, ttl time.Duration) error {
+	id, _ := req.Secret.InternalData["credential_id"].(string)
+	return b.refreshCredentialByID(ctx, req, id, ttl)
+}
+
+// refreshCredentialByID records the lease ID and new expiry of a renewed
+// secret in the inventory record with the given ID
+func (b *RollbarBackend) refreshCredentialByID(ctx context.Context, req *logical.Request, id string, ttl time.Duration) error {
+	if ttl == 0 {
+		ttl = b.System().DefaultLeaseTTL()
+	}
+
+	return b.updateCredential(ctx, req.Storage, id, func(cred *RollbarCredentialEntry) {
+		cred.LeaseID = req.Secret.LeaseID
+		cred.ExpiresAt = time.Now().UTC().Add(ttl)
+	})
+}
+
 func (b *RollbarBackend) projectAccessTokenRevoke(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	client, err := b.getClient(ctx, req.Storage)
 	if err != nil {
@@ -71,25 +223,228 @@ func (b *RollbarBackend) projectAccessTokenRevoke(ctx context.Context, req *logi
 		}
 	}
 
+	id, _ := req.Secret.InternalData["credential_id"].(string)
+	if id != "" {
+		cred, err := getCredential(ctx, req.Storage, id)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving credential: %w", err)
+		}
+		// the token was already revoked along with its role
+		if cred == nil {
+			return nil, nil
+		}
+	}
+
+	if reuseKey, _ := req.Secret.InternalData["reuse_key"].(string); reuseKey != "" {
+		role, _ := req.Secret.InternalData["role"].(string)
+		entityID, _ := req.Secret.InternalData["entity_id"].(string)
+
+		held, err := b.releaseReusableToken(ctx, req.Storage, reuseKey, role, entityID, id)
+		if err != nil {
+			return nil, fmt.Errorf("error releasing reused project access token: %w", err)
+		}
+		// other leases still hand out the same token
+		if held {
+			if err := b.deleteCredential(ctx, req.Storage, id); err != nil {
+				return nil, fmt.Errorf("error removing credential record: %w", err)
+			}
+			return nil, nil
+		}
+	}
+
+	projectID, err := b.secretProjectID(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := deleteProjectAccessToken(ctx, client, projectID, pat); err != nil {
+		if errors.Is(err, errRollbarUnavailable) {
+			return nil, fmt.Errorf("rollbar unavailable, deferring revocation of project access token: %w", err)
+		}
+		if !errors.Is(err, errRollbarNotFound) {
+			return nil, fmt.Errorf("error revoking project access token: %w", err)
+		}
+		b.Logger().Info("project access token already deleted in rollbar, treating revocation as successful", "credential_id", id, "project_id", projectID)
+	}
+
+	if claimKey, _ := req.Secret.InternalData["claim_key"].(string); claimKey != "" {
+		if err := req.Storage.Delete(ctx, claimKey); err != nil {
+			return nil, fmt.Errorf("error removing claim: %w", err)
+		}
+	}
+
+	if err := b.deleteCredential(ctx, req.Storage, id); err != nil {
+		return nil, fmt.Errorf("error removing credential record: %w", err)
+	}
+
+	return nil, nil
+}
+
+// secretProjectID returns the rollbar project a secret was issued for. Secrets
+// issued before the project was recorded in their internal data fall back
+// to the project of their role.
+func (b *RollbarBackend) secretProjectID(ctx context.Context, req *logical.Request) (int, error) {
+	if projectID, ok := req.Secret.InternalData["project_id"]; ok {
+		switch v := projectID.(type) {
+		case int:
+			return v, nil
+		case float64:
+			return int(v), nil
+		case json.Number:
+			id, err := v.Int64()
+			return int(id), err
+		}
+	}
+
 	roleRaw, ok := req.Secret.InternalData["role"]
 	if !ok {
-		return nil, fmt.Errorf("secret is missing role internal data")
+		return 0, fmt.Errorf("secret is missing role internal data")
 	}
 
 	// get the role entry
 	role := roleRaw.(string)
 	roleEntry, err := b.getRole(ctx, req.Storage, role)
 	if err != nil {
-		return nil, fmt.Errorf("error retrieving role: %w", err)
+		return 0, fmt.Errorf("error retrieving role: %w", err)
 	}
 
-	if err := deleteProjectAccessToken(ctx, client, roleEntry.ProjectID, pat); err != nil {
-		return nil, fmt.Errorf("error revoking project access token: %w", err)
+	if roleEntry == nil {
+		return 0, fmt.Errorf("error retrieving role: role %q no longer exists", role)
 	}
-	return nil, nil
+
+	return roleEntry.ProjectID, nil
+}
+
+// leasesRemainWarning is returned when tokens are deleted out of band,
+// leaving their leases behind
+const leasesRemainWarning = "the tokens were deleted in rollbar but their Vault leases remain listed until they expire; remove them with vault lease revoke -prefix on lease_prefixes"
+
+// outstandingLeases returns the lease IDs known for the given credentials,
+// and the lease prefixes their leases were issued under. The backend cannot
+// revoke leases itself, so after their tokens are deleted out of band the
+// leases stay listed until they expire or are revoked by an operator. Lease
+// IDs are only known for leases that were renewed.
+func outstandingLeases(req *logical.Request, creds []*RollbarCredentialEntry) ([]string, []string) {
+	leaseIDs := []string{}
+	seen := map[string]bool{}
+	prefixes := []string{}
+	for _, cred := range creds {
+		if cred.LeaseID != "" {
+			leaseIDs = append(leaseIDs, cred.LeaseID)
+		}
+
+		prefix := req.MountPoint + projectAccessTokenPath + cred.Role + "/"
+		if cred.Type == roleTypeAccount {
+			prefix = req.MountPoint + accountAccessTokenPath + cred.Role + "/"
+		}
+		if !seen[prefix] {
+			seen[prefix] = true
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	sort.Strings(leaseIDs)
+	sort.Strings(prefixes)
+
+	return leaseIDs, prefixes
+}
+
+// revokeCredentials deletes the rollbar tokens behind the given inventory
+// records and removes the records. Tokens rollbar no longer knows are
+// treated as revoked. It returns the IDs it revoked and the errors met
+// per credential.
+func (b *RollbarBackend) revokeCredentials(ctx context.Context, s logical.Storage, creds []*RollbarCredentialEntry) ([]string, map[string]string, error) {
+	client, err := b.getClient(ctx, s)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting client: %w", err)
+	}
+
+	byProject := map[int][]*RollbarCredentialEntry{}
+	accountCreds := []*RollbarCredentialEntry{}
+	for _, cred := range creds {
+		if cred.Type == roleTypeAccount {
+			accountCreds = append(accountCreds, cred)
+			continue
+		}
+		byProject[cred.ProjectID] = append(byProject[cred.ProjectID], cred)
+	}
+
+	revoked := []string{}
+	failed := map[string]string{}
+
+	if len(accountCreds) > 0 {
+		tokens, err := client.ListAccountAccessTokens(ctx)
+		if err != nil {
+			for _, cred := range accountCreds {
+				failed[cred.ID] = err.Error()
+			}
+		} else {
+			byName := make(map[string]rollbarAccountAccessToken, len(tokens))
+			for _, token := range tokens {
+				byName[token.Name] = token
+			}
+
+			for _, cred := range accountCreds {
+				if token, ok := byName[cred.TokenName]; ok {
+					if err := deleteAccountAccessToken(ctx, client, token.AccessToken); err != nil && !errors.Is(err, errRollbarNotFound) {
+						failed[cred.ID] = err.Error()
+						continue
+					}
+				}
+
+				if err := b.deleteCredential(ctx, s, cred.ID); err != nil {
+					failed[cred.ID] = err.Error()
+					continue
+				}
+				revoked = append(revoked, cred.ID)
+				b.recordEvent(ctx, s, credentialEvent(eventRevoke, cred))
+			}
+		}
+	}
+
+	for projectID, projectCreds := range byProject {
+		tokens, err := client.ListProjectAccessTokens(ctx, projectID)
+		if err != nil {
+			for _, cred := range projectCreds {
+				failed[cred.ID] = err.Error()
+			}
+			continue
+		}
+
+		byName := make(map[string]rollbarProjectAccessToken, len(tokens))
+		for _, token := range tokens {
+			byName[token.Name] = token
+		}
+
+		for _, cred := range projectCreds {
+			if token, ok := byName[cred.TokenName]; ok {
+				if err := deleteProjectAccessToken(ctx, client, projectID, token.AccessToken); err != nil && !errors.Is(err, errRollbarNotFound) {
+					failed[cred.ID] = err.Error()
+					continue
+				}
+			}
+
+			if err := b.deleteCredential(ctx, s, cred.ID); err != nil {
+				failed[cred.ID] = err.Error()
+				continue
+			}
+			revoked = append(revoked, cred.ID)
+			b.recordEvent(ctx, s, credentialEvent(eventRevoke, cred))
+		}
+	}
+
+	for _, cred := range creds {
+		if reason, ok := failed[cred.ID]; ok {
+			b.recordEvent(ctx, s, credentialEvent(eventRevoke, cred).failed(errors.New(reason)))
+		}
+	}
+
+	sort.Strings(revoked)
+
+	return revoked, failed, nil
 }
 
-func createProjectAccessToken(ctx context.Context, c *rollbarClient, scopes string, projectID int, name string) (*string, error) {
+func createProjectAccessToken(ctx context.Context, c *rollbarClient, scopes []string, projectID int, name string) (*rollbarProjectAccessToken, error) {
 	return c.CreateProjectAccessToken(ctx, scopes, projectID, name)
 }
 
@@ -0,0 +1,157 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	rollbarProjectAccessTokenBatchType = "rollbar_project_access_token_batch"
+)
+
+// batchToken is a token issued as part of a batch, as kept in the
+// internal data of the batch secret
+type batchToken struct {
+	CredentialID       string `json:"credential_id"`
+	ProjectAccessToken string `json:"project_access_token"`
+}
+
+func (b *RollbarBackend) rollbarProjectAccessTokenBatch() *framework.Secret {
+
+	return &framework.Secret{
+		Type: rollbarProjectAccessTokenBatchType,
+		Fields: map[string]*framework.FieldSchema{
+			"project_access_tokens": {
+				Type:        framework.TypeSlice,
+				Description: "Rollbar Project Access Tokens issued in the batch",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+		},
+		Renew:  b.withSecretHistory(eventRenew, b.projectAccessTokenBatchRenew),
+		Revoke: b.withSecretHistory(eventRevoke, b.projectAccessTokenBatchRevoke),
+	}
+}
+
+func (b *RollbarBackend) projectAccessTokenBatchRenew(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	roleRaw, ok := req.Secret.InternalData["role"]
+	if !ok {
+		return nil, fmt.Errorf("secret is missing role internal data")
+	}
+
+	// get the role entry
+	role := roleRaw.(string)
+	roleEntry, err := b.getRole(ctx, req.Storage, role)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving role: %w", err)
+	}
+
+	if roleEntry == nil {
+		return nil, errors.New("error retrieving role: role is nil")
+	}
+
+	tokens, err := secretBatchTokens(req.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	projectID, err := b.secretProjectID(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// the batch shares one lease, so it is only renewed while every token
+	// in it is still alive
+	if err := b.checkTokensRenewable(ctx, req, projectID, tokens); err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{Secret: req.Secret}
+	if roleEntry.TTL > 0 {
+		resp.Secret.TTL = roleEntry.TTL
+	}
+	if roleEntry.MaxTTL > 0 {
+		resp.Secret.MaxTTL = roleEntry.MaxTTL
+	}
+
+	for _, token := range tokens {
+		if err := b.refreshCredentialByID(ctx, req, token.CredentialID, resp.Secret.TTL); err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+func (b *RollbarBackend) projectAccessTokenBatchRevoke(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
+	}
+
+	tokens, err := secretBatchTokens(req.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	projectID, err := b.secretProjectID(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	failed := []string{}
+	for _, token := range tokens {
+		cred, err := getCredential(ctx, req.Storage, token.CredentialID)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", token.CredentialID, err))
+			continue
+		}
+		// the token was already revoked along with its role
+		if cred == nil {
+			continue
+		}
+
+		if err := deleteProjectAccessToken(ctx, client, projectID, token.ProjectAccessToken); err != nil && !errors.Is(err, errRollbarNotFound) {
+			failed = append(failed, fmt.Sprintf("%s: %s", token.CredentialID, err))
+			continue
+		}
+
+		if err := b.deleteCredential(ctx, req.Storage, token.CredentialID); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", token.CredentialID, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return nil, fmt.Errorf("error revoking %d of %d batch project access tokens: %s", len(failed), len(tokens), strings.Join(failed, "; "))
+	}
+
+	return nil, nil
+}
+
+// secretBatchTokens decodes the tokens kept in the internal data of a
+// batch secret
+func secretBatchTokens(secret *logical.Secret) ([]batchToken, error) {
+	raw, ok := secret.InternalData["tokens"]
+	if !ok {
+		return nil, fmt.Errorf("secret is missing tokens internal data")
+	}
+
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []batchToken
+	if err := json.Unmarshal(encoded, &tokens); err != nil {
+		return nil, fmt.Errorf("invalid value for tokens in secret internal data: %w", err)
+	}
+
+	return tokens, nil
+}
@@ -0,0 +1,99 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestProjectAccessTokenBatchRollsBackStrays(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeProjectTokens()
+	// the third create reaches rollbar but its response is lost
+	fake.failCreate = func(n int) bool { return n == 3 }
+	_, config := newFakeRollbar(t, fake.ServeHTTP)
+
+	b, s := newTestBackend(t, config)
+	role := &RollbarRoleEntry{
+		Name:                     "test",
+		RoleType:                 roleTypeProject,
+		ProjectID:                1,
+		ProjectAccessTokenScopes: []string{"read"},
+		MaxBatchSize:             10,
+	}
+	if err := setRole(ctx, s, role.Name, role); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "projectaccesstoken/test/batch",
+		Storage:   s,
+		Data:      map[string]interface{}{"count": 6},
+	})
+	if err == nil {
+		t.Fatal("expected the batch to fail")
+	}
+
+	if names := fake.names(); len(names) > 0 {
+		t.Errorf("tokens left in rollbar after rollback: %v", names)
+	}
+
+	creds, err := listCredentials(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(creds) > 0 {
+		t.Errorf("%d credential records left after rollback", len(creds))
+	}
+}
+
+func TestProjectAccessTokenBatchRenewChecksEveryToken(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeProjectTokens()
+	_, config := newFakeRollbar(t, fake.ServeHTTP)
+
+	b, s := newTestBackend(t, config)
+	role := &RollbarRoleEntry{
+		Name:                     "test",
+		RoleType:                 roleTypeProject,
+		ProjectID:                1,
+		ProjectAccessTokenScopes: []string{"read"},
+		MaxBatchSize:             10,
+	}
+	if err := setRole(ctx, s, role.Name, role); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "projectaccesstoken/test/batch",
+		Storage:   s,
+		Data:      map[string]interface{}{"count": 3},
+	})
+	if err != nil || resp == nil || resp.Secret == nil {
+		t.Fatalf("issuing batch: resp %#v, err %v", resp, err)
+	}
+
+	renew := &logical.Request{
+		Operation: logical.RenewOperation,
+		Storage:   s,
+		Secret:    resp.Secret,
+	}
+	if _, err := b.HandleRequest(ctx, renew); err != nil {
+		t.Fatalf("renewing live batch: %v", err)
+	}
+
+	// one token of the batch is deleted out of band
+	fake.mu.Lock()
+	for pat := range fake.tokens {
+		delete(fake.tokens, pat)
+		break
+	}
+	fake.mu.Unlock()
+
+	if _, err := b.HandleRequest(ctx, renew); err == nil {
+		t.Fatal("expected renewing a batch with a deleted token to fail")
+	}
+}
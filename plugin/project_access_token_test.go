@@ -0,0 +1,121 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// newTestBackend returns a backend on in-memory storage, configured with
+// the given config when it is not nil
+func newTestBackend(t *testing.T, config *RollbarConfig) (*RollbarBackend, logical.Storage) {
+	t.Helper()
+
+	ctx := context.Background()
+	conf := logical.TestBackendConfig()
+	conf.StorageView = &logical.InmemStorage{}
+
+	b := newBackend()
+	if err := b.Setup(ctx, conf); err != nil {
+		t.Fatal(err)
+	}
+
+	if config != nil {
+		if err := setConfig(ctx, conf.StorageView, config); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return b, conf.StorageView
+}
+
+// revokeRequest returns the revoke request Vault sends when the lease of a
+// project access token is revoked
+func revokeRequest(s logical.Storage, credID string) *logical.Request {
+	return &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   s,
+		Secret: &logical.Secret{
+			LeaseOptions: logical.LeaseOptions{TTL: time.Hour},
+			InternalData: map[string]interface{}{
+				"secret_type":          rollbarProjectAccessTokenType,
+				"project_access_token": "tok",
+				"role":                 "test",
+				"project_id":           1,
+				"credential_id":        credID,
+			},
+		},
+	}
+}
+
+func TestProjectAccessTokenRevoke(t *testing.T) {
+	cases := []struct {
+		name    string
+		handler http.HandlerFunc
+		closed  bool
+		wantErr bool
+	}{
+		{
+			name:    "deleted",
+			handler: respond(http.StatusOK, `{"err":0}`, 0),
+		},
+		{
+			name:    "already deleted in rollbar",
+			handler: respond(http.StatusNotFound, `{"err":1,"message":"Not found"}`, 0),
+		},
+		{
+			name:    "server error",
+			handler: respond(http.StatusInternalServerError, `{"err":1,"message":"Internal error"}`, 0),
+			wantErr: true,
+		},
+		{
+			name:    "timeout",
+			handler: respond(http.StatusOK, `{"err":0}`, time.Second),
+			wantErr: true,
+		},
+		{
+			name:    "connection refused",
+			handler: respond(http.StatusOK, `{"err":0}`, 0),
+			closed:  true,
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			server, config := newFakeRollbar(t, tc.handler)
+			config.DeleteTimeout = 50 * time.Millisecond
+			if tc.closed {
+				server.Close()
+			}
+
+			b, s := newTestBackend(t, config)
+			cred := &RollbarCredentialEntry{ID: "cred", Type: roleTypeProject, Role: "test", ProjectID: 1, TokenName: "test-cred"}
+			if err := setCredential(ctx, s, cred); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := b.HandleRequest(ctx, revokeRequest(s, cred.ID))
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("got error %v, want error %t", err, tc.wantErr)
+			}
+
+			// Vault keeps the lease and retries when revocation fails, so
+			// the inventory record must stay until it succeeds
+			stored, err := getCredential(ctx, s, cred.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantErr && stored == nil {
+				t.Error("credential record removed although revocation failed")
+			}
+			if !tc.wantErr && stored != nil {
+				t.Error("credential record kept although revocation succeeded")
+			}
+		})
+	}
+}
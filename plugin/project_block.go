@@ -0,0 +1,84 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	projectBlockStoragePath = "project_blocks/"
+)
+
+// projectBlock stops the backend from issuing tokens for a rollbar project
+// until an operator clears it
+type projectBlock struct {
+	ProjectID         int       `json:"project_id"`
+	Reason            string    `json:"reason"`
+	EntityID          string    `json:"entity_id"`
+	EntityDisplayName string    `json:"entity_display_name"`
+	BlockedAt         time.Time `json:"blocked_at"`
+}
+
+// projectBlockKey returns the storage key of the block of a project
+func projectBlockKey(projectID int) string {
+	return projectBlockStoragePath + strconv.Itoa(projectID)
+}
+
+// checkProjectBlocked returns an error response when issuance for a
+// project is blocked
+func checkProjectBlocked(ctx context.Context, s logical.Storage, projectID int) (*logical.Response, error) {
+	block, err := getProjectBlock(ctx, s, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("error checking project block: %w", err)
+	}
+
+	if block == nil {
+		return nil, nil
+	}
+
+	return logical.ErrorResponse("issuance for rollbar project %d is blocked since %s; clear projects/%d/block to resume", projectID, block.BlockedAt.Format(time.RFC3339), projectID), nil
+}
+
+// getProjectBlock gets the block of a project from the Vault storage API
+func getProjectBlock(ctx context.Context, s logical.Storage, projectID int) (*projectBlock, error) {
+	entry, err := s.Get(ctx, projectBlockKey(projectID))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	block := new(projectBlock)
+	if err := entry.DecodeJSON(block); err != nil {
+		return nil, fmt.Errorf("error reading block of project %d: %w", projectID, err)
+	}
+
+	return block, nil
+}
+
+// setProjectBlock sets the block of a project into the Vault storage API
+func setProjectBlock(ctx context.Context, s logical.Storage, block *projectBlock) error {
+	entry, err := logical.StorageEntryJSON(projectBlockKey(block.ProjectID), block)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// toResponseData returns response data for a project block
+func (p *projectBlock) toResponseData() map[string]interface{} {
+	return map[string]interface{}{
+		"project_id":          p.ProjectID,
+		"reason":              p.Reason,
+		"entity_id":           p.EntityID,
+		"entity_display_name": p.EntityDisplayName,
+		"blocked_at":          p.BlockedAt,
+	}
+}
@@ -0,0 +1,251 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	quotaStoragePath          = "quota/"
+	defaultIssuanceRateWindow = time.Hour
+
+	// entityTokensStoragePath indexes the tokens an entity holds from a role
+	// as entity_tokens/<role>/<entity ID>/<token name>/<credential ID>, so
+	// they can be counted with a single list of the entity's prefix
+	entityTokensStoragePath = "entity_tokens/"
+)
+
+// entityUsage tracks the recent issuances of an entity from a role for the
+// role's per-entity issuance rate quota
+type entityUsage struct {
+	Issued []time.Time `json:"issued"`
+}
+
+// quotaKey returns the storage key of an entity's usage of a role
+func quotaKey(role, entityID string) string {
+	return quotaStoragePath + role + "/" + entityID
+}
+
+// entityTokenKey returns the storage key indexing a token held by an entity
+// from a role. Records from before token names were kept are indexed by
+// their ID.
+func entityTokenKey(role, entityID, tokenName, credID string) string {
+	if tokenName == "" {
+		tokenName = credID
+	}
+	return entityTokensStoragePath + role + "/" + entityID + "/" + tokenName + "/" + credID
+}
+
+// issuanceRateWindow returns the window of a role's per-entity issuance
+// rate quota
+func (r *RollbarRoleEntry) issuanceRateWindow() time.Duration {
+	if r.IssuanceRateWindow > 0 {
+		return r.IssuanceRateWindow
+	}
+	return defaultIssuanceRateWindow
+}
+
+// inWindow returns the issuances still within the given window
+func (u *entityUsage) inWindow(window time.Duration) []time.Time {
+	since := time.Now().Add(-window)
+
+	issued := make([]time.Time, 0, len(u.Issued))
+	for _, t := range u.Issued {
+		if t.After(since) {
+			issued = append(issued, t)
+		}
+	}
+
+	return issued
+}
+
+// lockQuota serializes checking and recording the usage of an entity for
+// a role. It returns the unlock function.
+func (b *RollbarBackend) lockQuota(role, entityID string) func() {
+	lock := locksutil.LockForKey(b.quotaLocks, quotaKey(role, entityID))
+	lock.Lock()
+	return lock.Unlock
+}
+
+// countEntityTokens returns the number of distinct rollbar tokens an entity
+// holds from a role. A reused token is indexed once per credential under
+// the same token name, so it counts once.
+func countEntityTokens(ctx context.Context, s logical.Storage, role, entityID string) (int, error) {
+	names, err := s.List(ctx, entityTokensStoragePath+role+"/"+entityID+"/")
+	if err != nil {
+		return 0, err
+	}
+
+	return len(names), nil
+}
+
+// checkEntityQuota returns an error response when issuing count more tokens
+// to an entity would exceed the role's per-entity quotas. Requests without
+// an entity, such as those made with the root token, are not limited. The
+// caller must hold the entity's quota lock until the issuance is recorded.
+func (b *RollbarBackend) checkEntityQuota(ctx context.Context, s logical.Storage, roleEntry *RollbarRoleEntry, entityID string, count int) (*logical.Response, error) {
+	if entityID == "" {
+		return nil, nil
+	}
+
+	if roleEntry.MaxTokensPerEntity > 0 {
+		held, err := countEntityTokens(ctx, s, roleEntry.Name, entityID)
+		if err != nil {
+			return nil, fmt.Errorf("error counting entity tokens: %w", err)
+		}
+		if held+count > roleEntry.MaxTokensPerEntity {
+			return logical.ErrorResponse("quota exceeded: entity %q holds %d of the %d tokens allowed per entity by role %q", entityID, held, roleEntry.MaxTokensPerEntity, roleEntry.Name), nil
+		}
+	}
+
+	if roleEntry.MaxIssuanceRatePerEntity > 0 {
+		usage, err := getEntityUsage(ctx, s, roleEntry.Name, entityID)
+		if err != nil {
+			return nil, err
+		}
+		window := roleEntry.issuanceRateWindow()
+		issued := len(usage.inWindow(window))
+		if issued+count > roleEntry.MaxIssuanceRatePerEntity {
+			return logical.ErrorResponse("quota exceeded: entity %q was issued %d of the %d tokens allowed per %s by role %q", entityID, issued, roleEntry.MaxIssuanceRatePerEntity, window, roleEntry.Name), nil
+		}
+	}
+
+	return nil, nil
+}
+
+// recordEntityIssuance records count issuances to an entity against the
+// role's per-entity issuance rate quota
+func recordEntityIssuance(ctx context.Context, s logical.Storage, roleEntry *RollbarRoleEntry, entityID string, count int) error {
+	if entityID == "" || roleEntry.MaxIssuanceRatePerEntity == 0 {
+		return nil
+	}
+
+	usage, err := getEntityUsage(ctx, s, roleEntry.Name, entityID)
+	if err != nil {
+		return err
+	}
+
+	usage.Issued = usage.inWindow(roleEntry.issuanceRateWindow())
+	now := time.Now().UTC()
+	for i := 0; i < count; i++ {
+		usage.Issued = append(usage.Issued, now)
+	}
+
+	entry, err := logical.StorageEntryJSON(quotaKey(roleEntry.Name, entityID), usage)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// getEntityUsage gets the usage of a role by an entity from the Vault
+// storage API, returning an empty usage when none was recorded
+func getEntityUsage(ctx context.Context, s logical.Storage, role, entityID string) (*entityUsage, error) {
+	entry, err := s.Get(ctx, quotaKey(role, entityID))
+	if err != nil {
+		return nil, err
+	}
+
+	usage := new(entityUsage)
+	if entry == nil {
+		return usage, nil
+	}
+
+	if err := entry.DecodeJSON(usage); err != nil {
+		return nil, fmt.Errorf("error reading usage of entity %q: %w", entityID, err)
+	}
+
+	return usage, nil
+}
+
+// pruneEntityUsage deletes the recorded usage of entities whose issuances
+// have all left their role's issuance rate window, and the usage recorded
+// for roles that no longer exist
+func (b *RollbarBackend) pruneEntityUsage(ctx context.Context, s logical.Storage) error {
+	roles, err := s.List(ctx, quotaStoragePath)
+	if err != nil {
+		return err
+	}
+
+	pruned := 0
+	for _, role := range roles {
+		role = strings.TrimSuffix(role, "/")
+
+		roleEntry, err := b.getRole(ctx, s, role)
+		if err != nil {
+			return fmt.Errorf("error retrieving role %q: %w", role, err)
+		}
+
+		entities, err := s.List(ctx, quotaStoragePath+role+"/")
+		if err != nil {
+			return err
+		}
+
+		for _, entityID := range entities {
+			expired, err := b.pruneUsage(ctx, s, role, roleEntry, entityID)
+			if err != nil {
+				return err
+			}
+			if expired {
+				pruned++
+			}
+		}
+	}
+
+	if pruned > 0 {
+		b.Logger().Debug("pruned expired entity usage", "entries", pruned)
+	}
+
+	return nil
+}
+
+// pruneUsage deletes an entity's usage of a role once none of its
+// issuances are within the role's window. It reports whether the usage
+// was deleted.
+func (b *RollbarBackend) pruneUsage(ctx context.Context, s logical.Storage, role string, roleEntry *RollbarRoleEntry, entityID string) (bool, error) {
+	unlock := b.lockQuota(role, entityID)
+	defer unlock()
+
+	if roleEntry != nil {
+		usage, err := getEntityUsage(ctx, s, role, entityID)
+		if err != nil {
+			return false, err
+		}
+		if len(usage.inWindow(roleEntry.issuanceRateWindow())) > 0 {
+			return false, nil
+		}
+	}
+
+	if err := s.Delete(ctx, quotaKey(role, entityID)); err != nil {
+		return false, fmt.Errorf("error deleting usage of entity %q: %w", entityID, err)
+	}
+
+	return true, nil
+}
+
+// indexEntityTokens indexes the tokens of every credential in the
+// inventory under the entity they were issued to, for storage written
+// before the index existed
+func indexEntityTokens(ctx context.Context, s logical.Storage) error {
+	creds, err := listCredentials(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	for _, cred := range creds {
+		if cred.EntityID == "" {
+			continue
+		}
+		if err := s.Put(ctx, &logical.StorageEntry{Key: entityTokenKey(cred.Role, cred.EntityID, cred.TokenName, cred.ID), Value: []byte{}}); err != nil {
+			return fmt.Errorf("error indexing credential %q: %w", cred.ID, err)
+		}
+	}
+
+	return nil
+}
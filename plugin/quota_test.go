@@ -0,0 +1,129 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// issueRequest returns a request issuing a project access token from the
+// test role to an entity
+func issueRequest(s logical.Storage, entityID string) *logical.Request {
+	return &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "projectaccesstoken/test",
+		Storage:   s,
+		EntityID:  entityID,
+	}
+}
+
+func TestEntityQuotaRejectsTokensOverLimit(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeProjectTokens()
+	_, config := newFakeRollbar(t, fake.ServeHTTP)
+
+	b, s := newTestBackend(t, config)
+	role := &RollbarRoleEntry{
+		Name:                     "test",
+		RoleType:                 roleTypeProject,
+		ProjectID:                1,
+		ProjectAccessTokenScopes: []string{"read"},
+		MaxTokensPerEntity:       1,
+	}
+	if err := setRole(ctx, s, role.Name, role); err != nil {
+		t.Fatal(err)
+	}
+
+	issued, err := b.HandleRequest(ctx, issueRequest(s, "entity-a"))
+	if err != nil || issued.IsError() {
+		t.Fatalf("issuing first token: %v %v", issued, err)
+	}
+
+	resp, err := b.HandleRequest(ctx, issueRequest(s, "entity-a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsError() {
+		t.Fatal("expected a second token for the entity to be rejected")
+	}
+
+	resp, err = b.HandleRequest(ctx, issueRequest(s, "entity-b"))
+	if err != nil || resp.IsError() {
+		t.Fatalf("issuing to another entity: %v %v", resp, err)
+	}
+
+	// revoking the token gives the entity its room back
+	_, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   s,
+		Secret:    issued.Secret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	held, err := countEntityTokens(ctx, s, "test", "entity-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held != 0 {
+		t.Errorf("entity holds %d tokens after revocation, want 0", held)
+	}
+
+	resp, err = b.HandleRequest(ctx, issueRequest(s, "entity-a"))
+	if err != nil || resp.IsError() {
+		t.Fatalf("issuing after revocation: %v %v", resp, err)
+	}
+}
+
+func TestPruneEntityUsage(t *testing.T) {
+	ctx := context.Background()
+	b, s := newTestBackend(t, nil)
+
+	role := &RollbarRoleEntry{
+		Name:                     "test",
+		RoleType:                 roleTypeProject,
+		ProjectID:                1,
+		MaxIssuanceRatePerEntity: 5,
+		IssuanceRateWindow:       time.Hour,
+	}
+	if err := setRole(ctx, s, role.Name, role); err != nil {
+		t.Fatal(err)
+	}
+
+	usages := map[string]*entityUsage{
+		quotaKey("test", "expired"): {Issued: []time.Time{time.Now().Add(-2 * time.Hour)}},
+		quotaKey("test", "recent"):  {Issued: []time.Time{time.Now().Add(-2 * time.Hour), time.Now()}},
+		quotaKey("gone", "entity"):  {Issued: []time.Time{time.Now()}},
+	}
+	for key, usage := range usages {
+		entry, err := logical.StorageEntryJSON(key, usage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Put(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := b.pruneEntityUsage(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]bool{
+		quotaKey("test", "expired"): false,
+		quotaKey("test", "recent"):  true,
+		quotaKey("gone", "entity"):  false,
+	}
+	for key, kept := range want {
+		entry, err := s.Get(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (entry != nil) != kept {
+			t.Errorf("usage %q kept is %t, want %t", key, entry != nil, kept)
+		}
+	}
+}
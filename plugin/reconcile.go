@@ -0,0 +1,266 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	reconcileReportStoragePath = "reconcile/report"
+	defaultReconcileInterval   = time.Hour
+
+	driftMissing    = "missing"
+	driftDisabled   = "disabled"
+	driftOutOfScope = "out_of_scope"
+)
+
+// driftFinding describes an issued token whose state in rollbar no
+// longer matches what the backend issued
+type driftFinding struct {
+	CredentialID string `json:"credential_id"`
+	Role         string `json:"role"`
+	ProjectID    int    `json:"project_id"`
+	TokenName    string `json:"token_name"`
+	Issue        string `json:"issue"`
+	Detail       string `json:"detail,omitempty"`
+}
+
+// reconcileReport is the outcome of a reconciliation run
+type reconcileReport struct {
+	StartedAt          time.Time         `json:"started_at"`
+	CompletedAt        time.Time         `json:"completed_at"`
+	CredentialsChecked int               `json:"credentials_checked"`
+	Findings           []driftFinding    `json:"findings"`
+	ProjectErrors      map[string]string `json:"project_errors"`
+}
+
+// reconcileIfDue runs the reconciliation job once the configured interval
+// has elapsed since the last run
+func (b *RollbarBackend) reconcileIfDue(ctx context.Context, s logical.Storage) error {
+	config, err := getConfig(ctx, s)
+	if err != nil {
+		return err
+	}
+	if config == nil || config.ReconcileInterval <= 0 {
+		return nil
+	}
+
+	b.lock.RLock()
+	due := time.Since(b.lastReconcile) >= config.ReconcileInterval
+	b.lock.RUnlock()
+	if !due {
+		return nil
+	}
+
+	report, err := b.reconcile(ctx, s)
+	if err != nil {
+		return fmt.Errorf("error reconciling issued tokens: %w", err)
+	}
+
+	if len(report.Findings) > 0 {
+		b.Logger().Warn("issued tokens drifted from rollbar state", "findings", len(report.Findings))
+	}
+
+	return nil
+}
+
+// reconcile compares every credential in the inventory with the tokens
+// rollbar reports for its project, flags drifted credentials and stores
+// the resulting report
+func (b *RollbarBackend) reconcile(ctx context.Context, s logical.Storage) (*reconcileReport, error) {
+	report := &reconcileReport{
+		StartedAt:     time.Now().UTC(),
+		Findings:      []driftFinding{},
+		ProjectErrors: map[string]string{},
+	}
+
+	client, err := b.getClient(ctx, s)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
+	}
+
+	creds, err := listCredentials(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	byProject := map[int][]*RollbarCredentialEntry{}
+	for _, cred := range creds {
+		// only project access tokens are reconciled
+		if cred.Type != roleTypeProject {
+			continue
+		}
+		byProject[cred.ProjectID] = append(byProject[cred.ProjectID], cred)
+	}
+
+	projectIDs := make([]int, 0, len(byProject))
+	for projectID := range byProject {
+		projectIDs = append(projectIDs, projectID)
+	}
+	sort.Ints(projectIDs)
+
+	for _, projectID := range projectIDs {
+		tokens, err := client.ListProjectAccessTokens(ctx, projectID)
+		if err != nil {
+			report.ProjectErrors[strconv.Itoa(projectID)] = err.Error()
+			continue
+		}
+
+		byName := make(map[string]rollbarProjectAccessToken, len(tokens))
+		for _, token := range tokens {
+			byName[token.Name] = token
+		}
+
+		for _, cred := range byProject[projectID] {
+			report.CredentialsChecked++
+
+			finding := checkDrift(cred, byName)
+			drift := ""
+			if finding != nil {
+				drift = finding.Issue
+				report.Findings = append(report.Findings, *finding)
+			}
+
+			if cred.Drift != drift {
+				// the record may have been renewed or revoked since it was
+				// listed, so only its drift is written back
+				err := b.updateCredential(ctx, s, cred.ID, func(cred *RollbarCredentialEntry) {
+					cred.Drift = drift
+				})
+				if err != nil {
+					return nil, fmt.Errorf("error updating credential %q: %w", cred.ID, err)
+				}
+			}
+		}
+	}
+
+	report.CompletedAt = time.Now().UTC()
+
+	entry, err := logical.StorageEntryJSON(reconcileReportStoragePath, report)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	b.lock.Lock()
+	b.lastReconcile = report.StartedAt
+	b.lock.Unlock()
+
+	return report, nil
+}
+
+// checkDrift compares an inventory record with the rollbar tokens of its
+// project, keyed by token name
+func checkDrift(cred *RollbarCredentialEntry, tokens map[string]rollbarProjectAccessToken) *driftFinding {
+	finding := &driftFinding{
+		CredentialID: cred.ID,
+		Role:         cred.Role,
+		ProjectID:    cred.ProjectID,
+		TokenName:    cred.TokenName,
+	}
+
+	token, ok := tokens[cred.TokenName]
+	if !ok {
+		finding.Issue = driftMissing
+		return finding
+	}
+
+	// tokens waiting to be claimed are created disabled
+	if token.Status != tokenStatusEnabled && !cred.PendingClaim {
+		finding.Issue = driftDisabled
+		finding.Detail = fmt.Sprintf("token status is %q", token.Status)
+		return finding
+	}
+
+	if !sameScopes(cred.Scopes, token.Scopes) {
+		finding.Issue = driftOutOfScope
+		finding.Detail = fmt.Sprintf("token scopes are %q, issued with %q", strings.Join(token.Scopes, ","), strings.Join(cred.Scopes, ","))
+		return finding
+	}
+
+	return nil
+}
+
+// getReconcileReport gets the last reconciliation report from the Vault storage API
+func getReconcileReport(ctx context.Context, s logical.Storage) (*reconcileReport, error) {
+	entry, err := s.Get(ctx, reconcileReportStoragePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	report := new(reconcileReport)
+	if err := entry.DecodeJSON(report); err != nil {
+		return nil, fmt.Errorf("error reading reconciliation report: %w", err)
+	}
+
+	return report, nil
+}
+
+// verifyProjectAccessTokens checks with rollbar that issued tokens of a
+// project still exist and are enabled, with a single listing of the
+// project's tokens. It returns the drift of each token, empty for tokens
+// that are fine.
+func verifyProjectAccessTokens(ctx context.Context, c *rollbarClient, projectID int, pats []string) (map[string]string, error) {
+	ctx, cancel := c.withTimeout(ctx, opVerify)
+	defer cancel()
+
+	tokens, err := c.ListProjectAccessTokens(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make(map[string]string, len(tokens))
+	for _, token := range tokens {
+		status[token.AccessToken] = token.Status
+	}
+
+	drifts := make(map[string]string, len(pats))
+	for _, pat := range pats {
+		st, ok := status[pat]
+		switch {
+		case !ok:
+			drifts[pat] = driftMissing
+		case st != tokenStatusEnabled:
+			drifts[pat] = driftDisabled
+		default:
+			drifts[pat] = ""
+		}
+	}
+
+	return drifts, nil
+}
+
+// toResponseData returns response data for a reconciliation report
+func (r *reconcileReport) toResponseData() map[string]interface{} {
+	findings := make([]map[string]interface{}, 0, len(r.Findings))
+	for _, f := range r.Findings {
+		findings = append(findings, map[string]interface{}{
+			"credential_id": f.CredentialID,
+			"role":          f.Role,
+			"project_id":    f.ProjectID,
+			"token_name":    f.TokenName,
+			"issue":         f.Issue,
+			"detail":        f.Detail,
+		})
+	}
+
+	return map[string]interface{}{
+		"started_at":          r.StartedAt,
+		"completed_at":        r.CompletedAt,
+		"credentials_checked": r.CredentialsChecked,
+		"findings":            findings,
+		"project_errors":      r.ProjectErrors,
+	}
+}
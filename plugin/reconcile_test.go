@@ -0,0 +1,119 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestReconcileDetectsDrift(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeProjectTokens()
+	fake.tokens["pat-ok"] = rollbarProjectAccessToken{AccessToken: "pat-ok", Name: "ok", Status: tokenStatusEnabled, Scopes: []string{"read"}}
+	fake.tokens["pat-disabled"] = rollbarProjectAccessToken{AccessToken: "pat-disabled", Name: "disabled", Status: tokenStatusDisabled, Scopes: []string{"read"}}
+	fake.tokens["pat-widened"] = rollbarProjectAccessToken{AccessToken: "pat-widened", Name: "widened", Status: tokenStatusEnabled, Scopes: []string{"read", "write"}}
+	_, config := newFakeRollbar(t, fake.ServeHTTP)
+	b, s := newTestBackend(t, config)
+
+	creds := []*RollbarCredentialEntry{
+		{ID: "ok", Type: roleTypeProject, Role: "test", ProjectID: 1, TokenName: "ok", Scopes: []string{"read"}},
+		{ID: "disabled", Type: roleTypeProject, Role: "test", ProjectID: 1, TokenName: "disabled", Scopes: []string{"read"}},
+		{ID: "widened", Type: roleTypeProject, Role: "test", ProjectID: 1, TokenName: "widened", Scopes: []string{"read"}},
+		{ID: "missing", Type: roleTypeProject, Role: "test", ProjectID: 1, TokenName: "missing", Scopes: []string{"read"}},
+	}
+	for _, cred := range creds {
+		if err := setCredential(ctx, s, cred); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := b.reconcile(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.CredentialsChecked != len(creds) {
+		t.Errorf("checked %d credentials, want %d", report.CredentialsChecked, len(creds))
+	}
+
+	want := map[string]string{
+		"ok":       "",
+		"disabled": driftDisabled,
+		"widened":  driftOutOfScope,
+		"missing":  driftMissing,
+	}
+	findings := map[string]string{}
+	for _, f := range report.Findings {
+		findings[f.CredentialID] = f.Issue
+	}
+	for id, drift := range want {
+		if findings[id] != drift {
+			t.Errorf("credential %q reported with drift %q, want %q", id, findings[id], drift)
+		}
+		cred, err := getCredential(ctx, s, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cred.Drift != drift {
+			t.Errorf("credential %q recorded with drift %q, want %q", id, cred.Drift, drift)
+		}
+	}
+
+	// renewing a token that is gone fails
+	_, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.RenewOperation,
+		Storage:   s,
+		Secret: &logical.Secret{
+			LeaseOptions: logical.LeaseOptions{TTL: time.Hour},
+			InternalData: map[string]interface{}{
+				"secret_type":          rollbarProjectAccessTokenType,
+				"project_access_token": "pat-missing",
+				"role":                 "test",
+				"project_id":           1,
+				"credential_id":        "missing",
+			},
+		},
+	})
+	if err == nil {
+		t.Error("expected renewing a missing token to fail")
+	}
+}
+
+func TestReconcileKeepsRevokedCredentialsRevoked(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeProjectTokens()
+	var b *RollbarBackend
+	var s logical.Storage
+	_, config := newFakeRollbar(t, func(w http.ResponseWriter, r *http.Request) {
+		// the credential is revoked while rollbar is being listed
+		if r.Method == http.MethodGet && b != nil {
+			if err := b.deleteCredential(context.Background(), s, "revoked"); err != nil {
+				t.Error(err)
+			}
+		}
+		fake.ServeHTTP(w, r)
+	})
+	b, s = newTestBackend(t, config)
+
+	cred := &RollbarCredentialEntry{ID: "revoked", Type: roleTypeProject, Role: "test", ProjectID: 1, TokenName: "revoked", Scopes: []string{"read"}}
+	if err := setCredential(ctx, s, cred); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.reconcile(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+
+	if cred, err := getCredential(ctx, s, "revoked"); err != nil || cred != nil {
+		t.Errorf("revoked credential brought back as %+v, %v", cred, err)
+	}
+	active, err := b.countActiveTokens(ctx, s, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active != 0 {
+		t.Errorf("%d active tokens after revocation, want 0", active)
+	}
+}
@@ -0,0 +1,211 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	reuseStoragePath         = "reuse/"
+	defaultReuseMinRemaining = 0.5
+)
+
+// reusableToken is a project access token handed out to an entity that may
+// be handed out again to the same entity. Holders lists the inventory IDs
+// of every credential sharing the token; the token is only deleted in
+// rollbar once the last of them is revoked. Entries are seal wrapped as
+// they hold the token value.
+type reusableToken struct {
+	TokenName    string                    `json:"token_name"`
+	ProjectID    int                       `json:"project_id"`
+	Scopes       []string                  `json:"scope_list"`
+	LegacyScopes string                    `json:"scopes,omitempty"`
+	Token        rollbarProjectAccessToken `json:"token"`
+	IssuedAt     time.Time                 `json:"issued_at"`
+	ExpiresAt    time.Time                 `json:"expires_at"`
+	Holders      []string                  `json:"holders"`
+}
+
+// reusable reports whether a token may be handed out again for a role, that
+// is whether it still matches the role and more than the role's minimum
+// fraction of its first lease remains
+func (r *reusableToken) reusable(roleEntry *RollbarRoleEntry) bool {
+	minRemaining := roleEntry.ReuseMinRemaining
+	if minRemaining <= 0 {
+		minRemaining = defaultReuseMinRemaining
+	}
+
+	lease := r.ExpiresAt.Sub(r.IssuedAt)
+	remaining := time.Until(r.ExpiresAt)
+
+	return r.ProjectID == roleEntry.ProjectID &&
+		sameScopes(r.Scopes, roleEntry.ProjectAccessTokenScopes) &&
+		remaining > time.Duration(float64(lease)*minRemaining)
+}
+
+// reuseEntityPath returns the storage prefix of the reusable tokens of an
+// entity for a role
+func reuseEntityPath(role, entityID string) string {
+	return reuseStoragePath + role + "/" + entityID + "/"
+}
+
+// lockReuse locks the reusable tokens of an entity for a role and returns
+// the unlock function
+func (b *RollbarBackend) lockReuse(role, entityID string) func() {
+	lock := locksutil.LockForKey(b.reuseLocks, reuseEntityPath(role, entityID))
+	lock.Lock()
+	return lock.Unlock
+}
+
+// findReusableToken returns a token the entity may be handed again for the
+// role along with its storage key, or nil when there is none. Entries whose
+// holders have all been revoked are removed along the way. The caller must
+// hold the entity's reuse lock.
+func (b *RollbarBackend) findReusableToken(ctx context.Context, s logical.Storage, roleEntry *RollbarRoleEntry, entityID string) (*reusableToken, string, error) {
+	prefix := reuseEntityPath(roleEntry.Name, entityID)
+	ids, err := s.List(ctx, prefix)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, id := range ids {
+		key := prefix + id
+		reused, err := getReusableToken(ctx, s, key)
+		if err != nil {
+			return nil, "", err
+		}
+		if reused == nil {
+			continue
+		}
+
+		if err := pruneHolders(ctx, s, reused); err != nil {
+			return nil, "", err
+		}
+		if len(reused.Holders) == 0 {
+			if err := s.Delete(ctx, key); err != nil {
+				return nil, "", err
+			}
+			continue
+		}
+
+		if reused.reusable(roleEntry) {
+			return reused, key, nil
+		}
+	}
+
+	return nil, "", nil
+}
+
+// holdReusableToken records a credential as a holder of a reusable token,
+// creating the entry for newly issued tokens. The caller must hold the
+// entity's reuse lock.
+func holdReusableToken(ctx context.Context, s logical.Storage, key string, reused *reusableToken, credID string) error {
+	reused.Holders = append(reused.Holders, credID)
+	return setReusableToken(ctx, s, key, reused)
+}
+
+// releaseReusableToken removes a credential from the holders of a reusable
+// token and reports whether other credentials still hold it, in which case
+// the token must not be deleted in rollbar
+func (b *RollbarBackend) releaseReusableToken(ctx context.Context, s logical.Storage, key, role, entityID, credID string) (bool, error) {
+	unlock := b.lockReuse(role, entityID)
+	defer unlock()
+
+	reused, err := getReusableToken(ctx, s, key)
+	if err != nil {
+		return false, err
+	}
+	if reused == nil {
+		return false, nil
+	}
+
+	holders := make([]string, 0, len(reused.Holders))
+	for _, id := range reused.Holders {
+		if id != credID {
+			holders = append(holders, id)
+		}
+	}
+	reused.Holders = holders
+
+	if err := pruneHolders(ctx, s, reused); err != nil {
+		return false, err
+	}
+
+	if len(reused.Holders) == 0 {
+		return false, s.Delete(ctx, key)
+	}
+
+	return true, setReusableToken(ctx, s, key, reused)
+}
+
+// dropReusableTokens removes every reusable token entry of a role
+func dropReusableTokens(ctx context.Context, s logical.Storage, role string) error {
+	keys, err := logical.CollectKeysWithPrefix(ctx, s, reuseStoragePath+role+"/")
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := s.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pruneHolders drops the holders of a reusable token whose inventory
+// records no longer exist, such as those revoked along with their role
+func pruneHolders(ctx context.Context, s logical.Storage, reused *reusableToken) error {
+	holders := make([]string, 0, len(reused.Holders))
+	for _, id := range reused.Holders {
+		cred, err := getCredential(ctx, s, id)
+		if err != nil {
+			return fmt.Errorf("error retrieving credential: %w", err)
+		}
+		if cred != nil {
+			holders = append(holders, id)
+		}
+	}
+	reused.Holders = holders
+
+	return nil
+}
+
+// getReusableToken gets a reusable token entry from the Vault storage API
+func getReusableToken(ctx context.Context, s logical.Storage, key string) (*reusableToken, error) {
+	entry, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	reused := new(reusableToken)
+	if err := entry.DecodeJSON(reused); err != nil {
+		return nil, fmt.Errorf("error reading reusable token %q: %w", key, err)
+	}
+	upgradeScopes(&reused.Scopes, &reused.LegacyScopes)
+
+	return reused, nil
+}
+
+// setReusableToken sets a reusable token entry into the Vault storage API
+func setReusableToken(ctx context.Context, s logical.Storage, key string, reused *reusableToken) error {
+	entry, err := logical.StorageEntryJSON(key, reused)
+	if err != nil {
+		return err
+	}
+
+	if entry == nil {
+		return fmt.Errorf("failed to create storage entry for reusable token")
+	}
+
+	return s.Put(ctx, entry)
+}
@@ -0,0 +1,73 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestReuseForEntity(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeProjectTokens()
+	_, config := newFakeRollbar(t, fake.ServeHTTP)
+	b, s := newTestBackend(t, config)
+
+	role := &RollbarRoleEntry{
+		Name:                     "test",
+		RoleType:                 roleTypeProject,
+		ProjectID:                1,
+		ProjectAccessTokenScopes: []string{"read"},
+		ReuseForEntity:           true,
+	}
+	if err := setRole(ctx, s, role.Name, role); err != nil {
+		t.Fatal(err)
+	}
+
+	issue := func(entityID string) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, issueRequest(s, entityID))
+		if err != nil || resp.IsError() {
+			t.Fatalf("issuing token to %q: %v %v", entityID, resp, err)
+		}
+		return resp
+	}
+
+	first := issue("entity-a")
+	second := issue("entity-a")
+	other := issue("entity-b")
+
+	if second.Data["project_access_token"] != first.Data["project_access_token"] || second.Data["reused"] != true {
+		t.Errorf("second request of the entity got %v, want %v reused", second.Data["project_access_token"], first.Data["project_access_token"])
+	}
+	if other.Data["project_access_token"] == first.Data["project_access_token"] || other.Data["reused"] == true {
+		t.Error("another entity was handed the same token")
+	}
+	if fake.creates != 2 {
+		t.Errorf("%d tokens created, want 2", fake.creates)
+	}
+
+	revoke := func(resp *logical.Response) {
+		t.Helper()
+		_, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.RevokeOperation,
+			Storage:   s,
+			Secret:    resp.Secret,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the token is kept while a lease still holds it
+	name := first.Data["token_name"].(string)
+	revoke(first)
+	if _, ok := fake.status(name); !ok {
+		t.Fatal("reused token deleted while still held")
+	}
+
+	revoke(second)
+	if _, ok := fake.status(name); ok {
+		t.Error("reused token kept after its last lease was revoked")
+	}
+}
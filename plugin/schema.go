@@ -0,0 +1,334 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// schemaStoragePath records the schema version the stored roles and
+	// config were last migrated to
+	schemaStoragePath = "schema"
+
+	// storageSchemaVersion is bumped along with either entry version, or
+	// when storage needs rewriting as the active token index did in 3, the
+	// entity token index in 5 and the scope lists of credentials, pooled
+	// and reusable tokens in 6
+	storageSchemaVersion = 6
+	roleSchemaVersion    = 2
+	configSchemaVersion  = 2
+)
+
+// roleMigrations[i] upgrades a role entry from schema version i to i+1.
+// Migrations must be idempotent: a standby applies them in memory on every
+// read until the active node has stored the upgraded entry.
+var roleMigrations = []func(*RollbarRoleEntry){
+	migrateRoleV1,
+	migrateRoleV2,
+}
+
+// configMigrations[i] upgrades the config from schema version i to i+1
+var configMigrations = []func(*RollbarConfig){
+	migrateConfigV1,
+	migrateConfigV2,
+}
+
+// schemaMarker is the schema version of the whole storage
+type schemaMarker struct {
+	Version int `json:"version"`
+}
+
+// migrateRoleV1 upgrades roles written before schema versions existed:
+// roles without a type are project roles, and scope strings are rewritten
+// without the whitespace and empty elements older versions kept
+func migrateRoleV1(r *RollbarRoleEntry) {
+	if r.RoleType == "" {
+		r.RoleType = roleTypeProject
+	}
+	r.LegacyProjectAccessTokenScopes = strings.Join(splitScopes(r.LegacyProjectAccessTokenScopes), ",")
+	r.LegacyAccountAccessTokenScopes = strings.Join(splitScopes(r.LegacyAccountAccessTokenScopes), ",")
+}
+
+// migrateRoleV2 moves the comma joined scope strings of older entries to
+// the scope lists. Entries without legacy scopes keep their lists.
+func migrateRoleV2(r *RollbarRoleEntry) {
+	if r.LegacyProjectAccessTokenScopes != "" {
+		r.ProjectAccessTokenScopes = splitScopes(r.LegacyProjectAccessTokenScopes)
+	}
+	if r.LegacyAccountAccessTokenScopes != "" {
+		r.AccountAccessTokenScopes = splitScopes(r.LegacyAccountAccessTokenScopes)
+	}
+	r.LegacyProjectAccessTokenScopes = ""
+	r.LegacyAccountAccessTokenScopes = ""
+}
+
+// migrateConfigV1 upgrades configs written before schema versions existed,
+// setting the defaults of the reconciliation interval and client rate
+// limit on configs written before they could be configured. A value
+// stored as zero disables them and is kept.
+func migrateConfigV1(c *RollbarConfig) {
+	if c.absent["reconcile_interval"] {
+		c.ReconcileInterval = defaultReconcileInterval
+	}
+	if c.absent["client_rate_limit"] {
+		c.ClientRateLimit = defaultClientRateLimit
+	}
+	if c.absent["client_rate_limit_burst"] {
+		c.ClientRateLimitBurst = defaultClientRateLimitBurst
+	}
+}
+
+// migrateConfigV2 sets the default history retention on configs written
+// before issuance history was kept, which would otherwise keep it forever.
+// A retention already set is kept, including zero to keep history forever.
+func migrateConfigV2(c *RollbarConfig) {
+	if c.absent["history_retention"] {
+		c.HistoryRetention = defaultHistoryRetention
+	}
+}
+
+// upgradeScopes moves the comma joined scopes of a credential, pooled or
+// reusable token stored before scopes were kept as lists. Like the role
+// migrations it is applied on every read, so standbys see the lists before
+// the active node has rewritten the entry.
+func upgradeScopes(scopes *[]string, legacy *string) {
+	if *legacy != "" {
+		*scopes = splitScopes(*legacy)
+	}
+	*legacy = ""
+}
+
+// upgradeRole applies the migrations a role entry is missing. It refuses
+// entries written by a newer version of the plugin.
+func upgradeRole(r *RollbarRoleEntry) error {
+	if r.SchemaVersion > roleSchemaVersion {
+		return fmt.Errorf("role %q has schema version %d, newer than the %d this plugin supports", r.Name, r.SchemaVersion, roleSchemaVersion)
+	}
+
+	for r.SchemaVersion < roleSchemaVersion {
+		roleMigrations[r.SchemaVersion](r)
+		r.SchemaVersion++
+	}
+
+	return nil
+}
+
+// upgradeConfig applies the migrations the config is missing. It refuses a
+// config written by a newer version of the plugin.
+func upgradeConfig(c *RollbarConfig) error {
+	if c.SchemaVersion > configSchemaVersion {
+		return fmt.Errorf("config has schema version %d, newer than the %d this plugin supports", c.SchemaVersion, configSchemaVersion)
+	}
+
+	for c.SchemaVersion < configSchemaVersion {
+		configMigrations[c.SchemaVersion](c)
+		c.SchemaVersion++
+	}
+	c.absent = nil
+
+	return nil
+}
+
+// configDefaultedFields are the config fields whose default is applied by a
+// migration when a stored config does not have them
+var configDefaultedFields = []string{
+	"reconcile_interval",
+	"history_retention",
+	"client_rate_limit",
+	"client_rate_limit_burst",
+}
+
+// UnmarshalJSON decodes a stored config, recording which of the fields
+// defaulted by migrations it does not have
+func (c *RollbarConfig) UnmarshalJSON(data []byte) error {
+	type storedConfig RollbarConfig
+	if err := json.Unmarshal(data, (*storedConfig)(c)); err != nil {
+		return err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	c.absent = map[string]bool{}
+	for _, name := range configDefaultedFields {
+		if _, ok := fields[name]; !ok {
+			c.absent[name] = true
+		}
+	}
+
+	return nil
+}
+
+// initialize migrates the stored roles and config to the current schema
+// when the plugin starts. Nodes that cannot write, such as performance
+// standbys and secondaries, skip it and upgrade entries in memory as they
+// read them.
+func (b *RollbarBackend) initialize(ctx context.Context, req *logical.InitializationRequest) error {
+	if !b.WriteSafeReplicationState() {
+		return nil
+	}
+
+	return b.migrateStorage(ctx, req.Storage)
+}
+
+// migrateStorage rewrites every role and the config in the current schema,
+// builds the indexes added since the stored version and records the schema
+// version. Entries already current are left alone,
+// so an interrupted migration is simply run again.
+func (b *RollbarBackend) migrateStorage(ctx context.Context, s logical.Storage) error {
+	marker := schemaMarker{}
+	entry, err := s.Get(ctx, schemaStoragePath)
+	if err != nil {
+		return err
+	}
+	if entry != nil {
+		if err := entry.DecodeJSON(&marker); err != nil {
+			return fmt.Errorf("error reading schema version: %w", err)
+		}
+	}
+
+	if marker.Version >= storageSchemaVersion {
+		return nil
+	}
+
+	names, err := s.List(ctx, pathRoleDef)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		roleEntry, stored, err := b.readRole(ctx, s, name)
+		if err != nil {
+			return fmt.Errorf("error migrating role %q: %w", name, err)
+		}
+		if roleEntry == nil || stored == roleSchemaVersion {
+			continue
+		}
+		if err := setRole(ctx, s, name, roleEntry); err != nil {
+			return fmt.Errorf("error migrating role %q: %w", name, err)
+		}
+		b.Logger().Info("migrated role", "role", name, "from", stored, "to", roleSchemaVersion)
+	}
+
+	config, stored, err := readConfig(ctx, s)
+	if err != nil {
+		return fmt.Errorf("error migrating config: %w", err)
+	}
+	if config != nil && stored != configSchemaVersion {
+		if err := setConfig(ctx, s, config); err != nil {
+			return fmt.Errorf("error migrating config: %w", err)
+		}
+		b.Logger().Info("migrated config", "from", stored, "to", configSchemaVersion)
+	}
+
+	if marker.Version < 3 {
+		if err := indexActiveTokens(ctx, s); err != nil {
+			return fmt.Errorf("error indexing active tokens: %w", err)
+		}
+		b.Logger().Info("indexed active tokens")
+	}
+
+	if marker.Version < 5 {
+		if err := indexEntityTokens(ctx, s); err != nil {
+			return fmt.Errorf("error indexing entity tokens: %w", err)
+		}
+		b.Logger().Info("indexed entity tokens")
+	}
+
+	if marker.Version < 6 {
+		if err := migrateScopeLists(ctx, s); err != nil {
+			return fmt.Errorf("error migrating scope lists: %w", err)
+		}
+		b.Logger().Info("migrated credential, pool and reuse scope lists")
+	}
+
+	marker.Version = storageSchemaVersion
+	entry, err = logical.StorageEntryJSON(schemaStoragePath, marker)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// migrateScopeLists rewrites the credentials, pooled tokens and reusable
+// tokens with their scopes as lists. Reading each entry upgrades it, so
+// every entry is simply stored again.
+func migrateScopeLists(ctx context.Context, s logical.Storage) error {
+	creds, err := listCredentials(ctx, s)
+	if err != nil {
+		return err
+	}
+	for _, cred := range creds {
+		if err := setCredential(ctx, s, cred); err != nil {
+			return fmt.Errorf("error migrating credential %q: %w", cred.ID, err)
+		}
+	}
+
+	roles, err := s.List(ctx, poolStoragePath)
+	if err != nil {
+		return err
+	}
+	for _, role := range roles {
+		role = strings.TrimSuffix(role, "/")
+		ids, err := s.List(ctx, poolStoragePath+role+"/")
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			pooled, err := getPooledToken(ctx, s, role, id)
+			if err != nil {
+				return err
+			}
+			if pooled == nil {
+				continue
+			}
+			entry, err := logical.StorageEntryJSON(poolStoragePath+role+"/"+id, pooled)
+			if err != nil {
+				return err
+			}
+			if err := s.Put(ctx, entry); err != nil {
+				return fmt.Errorf("error migrating pooled token %q: %w", id, err)
+			}
+		}
+	}
+
+	roles, err = s.List(ctx, reuseStoragePath)
+	if err != nil {
+		return err
+	}
+	for _, role := range roles {
+		role = strings.TrimSuffix(role, "/")
+		entities, err := s.List(ctx, reuseStoragePath+role+"/")
+		if err != nil {
+			return err
+		}
+		for _, entityID := range entities {
+			prefix := reuseEntityPath(role, strings.TrimSuffix(entityID, "/"))
+			keys, err := s.List(ctx, prefix)
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				reused, err := getReusableToken(ctx, s, prefix+key)
+				if err != nil {
+					return err
+				}
+				if reused == nil {
+					continue
+				}
+				if err := setReusableToken(ctx, s, prefix+key, reused); err != nil {
+					return fmt.Errorf("error migrating reusable token %q: %w", prefix+key, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
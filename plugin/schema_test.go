@@ -0,0 +1,254 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// roleFixtures are roles stored by earlier versions of the plugin, by file
+// under testdata/roles, with the entry they upgrade to
+var roleFixtures = map[string]*RollbarRoleEntry{
+	// the format before schema versions existed
+	"test.json": {
+		Name:                     "test",
+		RoleType:                 roleTypeProject,
+		ProjectID:                1234,
+		ProjectAccessTokenScopes: []string{"post_client_item", "post_server_item", "read", "write"},
+		TTL:                      time.Hour,
+		MaxTTL:                   3 * time.Hour,
+		SchemaVersion:            roleSchemaVersion,
+	},
+	"noscopes.json": {
+		Name:          "noscopes",
+		RoleType:      roleTypeProject,
+		ProjectID:     5678,
+		SchemaVersion: roleSchemaVersion,
+	},
+	// schema version 1, with comma joined scope strings
+	"reporting.json": {
+		Name:                     "reporting",
+		RoleType:                 roleTypeAccount,
+		AccountAccessTokenScopes: []string{"read"},
+		TTL:                      time.Hour,
+		MaxTTL:                   2 * time.Hour,
+		SchemaVersion:            roleSchemaVersion,
+	},
+}
+
+// configFixtures are configs stored by earlier versions of the plugin, by
+// file under testdata/config, with the config they upgrade to
+var configFixtures = map[string]*RollbarConfig{
+	// the format before schema versions existed
+	"baseline.json": {
+		AccountAccessToken:   "account-token",
+		ReconcileInterval:    defaultReconcileInterval,
+		HistoryRetention:     defaultHistoryRetention,
+		ClientRateLimit:      defaultClientRateLimit,
+		ClientRateLimitBurst: defaultClientRateLimitBurst,
+		SchemaVersion:        configSchemaVersion,
+	},
+	// the format before schema versions existed, with reconciliation and
+	// the client rate limit turned off
+	"disabled.json": {
+		AccountAccessToken: "account-token",
+		HistoryRetention:   defaultHistoryRetention,
+		SchemaVersion:      configSchemaVersion,
+	},
+	// schema version 1, from before issuance history was kept
+	"v1.json": {
+		AccountAccessToken: "account-token",
+		HistoryRetention:   defaultHistoryRetention,
+		SchemaVersion:      configSchemaVersion,
+	},
+	// schema version 1 with a retention already set, as restored from a
+	// version written after the retention was configured
+	"v1-retention.json": {
+		AccountAccessToken: "account-token",
+		HistoryRetention:   90 * 24 * time.Hour,
+		SchemaVersion:      configSchemaVersion,
+	},
+	// schema version 1 set to keep history forever
+	"v1-forever.json": {
+		AccountAccessToken: "account-token",
+		SchemaVersion:      configSchemaVersion,
+	},
+}
+
+// readFixture returns the content of a file under testdata
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+
+	raw, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestUpgradeRoleFixtures(t *testing.T) {
+	for file, want := range roleFixtures {
+		t.Run(file, func(t *testing.T) {
+			raw := readFixture(t, filepath.Join("roles", file))
+
+			roleEntry := new(RollbarRoleEntry)
+			if err := json.Unmarshal(raw, roleEntry); err != nil {
+				t.Fatal(err)
+			}
+			if err := upgradeRole(roleEntry); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(roleEntry, want) {
+				t.Fatalf("upgraded to %+v, want %+v", roleEntry, want)
+			}
+
+			// standbys upgrade on every read, so upgrading again must not
+			// change the entry
+			stored, err := json.Marshal(roleEntry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			again := new(RollbarRoleEntry)
+			if err := json.Unmarshal(stored, again); err != nil {
+				t.Fatal(err)
+			}
+			if err := upgradeRole(again); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(again, want) {
+				t.Errorf("upgraded again to %+v, want %+v", again, want)
+			}
+		})
+	}
+}
+
+func TestUpgradeConfigFixtures(t *testing.T) {
+	for file, want := range configFixtures {
+		t.Run(file, func(t *testing.T) {
+			raw := readFixture(t, filepath.Join("config", file))
+
+			config := new(RollbarConfig)
+			if err := json.Unmarshal(raw, config); err != nil {
+				t.Fatal(err)
+			}
+			if err := upgradeConfig(config); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(config, want) {
+				t.Errorf("upgraded to %+v, want %+v", config, want)
+			}
+		})
+	}
+}
+
+func TestMigrateStorageFixtures(t *testing.T) {
+	ctx := context.Background()
+	b, s := newTestBackend(t, nil)
+
+	for file := range roleFixtures {
+		name := strings.TrimSuffix(file, ".json")
+		raw := readFixture(t, filepath.Join("roles", file))
+		if err := s.Put(ctx, &logical.StorageEntry{Key: pathRoleDef + name, Value: raw}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	raw := readFixture(t, filepath.Join("config", "baseline.json"))
+	if err := s.Put(ctx, &logical.StorageEntry{Key: configStoragePath, Value: raw}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.migrateStorage(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+
+	for file, want := range roleFixtures {
+		name := strings.TrimSuffix(file, ".json")
+		roleEntry, stored, err := b.readRole(ctx, s, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored != roleSchemaVersion {
+			t.Errorf("role %q stored in schema version %d, want %d", name, stored, roleSchemaVersion)
+		}
+		if !reflect.DeepEqual(roleEntry, want) {
+			t.Errorf("role %q migrated to %+v, want %+v", name, roleEntry, want)
+		}
+
+		entry, err := s.Get(ctx, pathRoleDef+name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(entry.Value), `"project_access_token_scopes"`) {
+			t.Errorf("role %q still stores comma joined scopes: %s", name, entry.Value)
+		}
+	}
+
+	config, stored, err := readConfig(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != configSchemaVersion || !reflect.DeepEqual(config, configFixtures["baseline.json"]) {
+		t.Errorf("config migrated to %+v in schema version %d", config, stored)
+	}
+}
+
+func TestMigrateScopeLists(t *testing.T) {
+	ctx := context.Background()
+	b, s := newTestBackend(t, nil)
+
+	// entries stored before scopes were kept as lists
+	fixtures := map[string]string{
+		credsStoragePath + "legacy":                             filepath.Join("creds", "legacy.json"),
+		poolStoragePath + "test/legacy":                         filepath.Join("pool", "legacy.json"),
+		reuseEntityPath("test", "entity") + "vault-test-reused": filepath.Join("reuse", "legacy.json"),
+	}
+	for key, file := range fixtures {
+		if err := s.Put(ctx, &logical.StorageEntry{Key: key, Value: readFixture(t, file)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := b.migrateStorage(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"read", "write"}
+	cred, err := getCredential(ctx, s, "legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cred.Scopes, want) {
+		t.Errorf("credential scopes migrated to %v, want %v", cred.Scopes, want)
+	}
+	pooled, err := getPooledToken(ctx, s, "test", "legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pooled.Scopes, want) {
+		t.Errorf("pooled token scopes migrated to %v, want %v", pooled.Scopes, want)
+	}
+	reused, err := getReusableToken(ctx, s, reuseEntityPath("test", "entity")+"vault-test-reused")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reused.Scopes, want) {
+		t.Errorf("reusable token scopes migrated to %v, want %v", reused.Scopes, want)
+	}
+
+	for key := range fixtures {
+		entry, err := s.Get(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(entry.Value), `"scopes":"`) {
+			t.Errorf("%q still stores comma joined scopes: %s", key, entry.Value)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package plugin
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// apiStats tracks the outcome of recent rollbar API calls and the rate
+// limit rollbar last reported. It is owned by the backend so it survives
+// the client being rebuilt on configuration changes.
+type apiStats struct {
+	mu sync.RWMutex
+
+	lastSuccessAt time.Time
+	lastSuccessOp string
+	lastFailureAt time.Time
+	lastFailureOp string
+	lastError     string
+
+	rateLimit          int
+	rateLimitRemaining int
+	rateLimitReset     time.Time
+	rateLimitSeenAt    time.Time
+}
+
+// record notes the outcome of a rollbar call
+func (s *apiStats) record(op string, err error) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	if err == nil {
+		s.lastSuccessAt = now
+		s.lastSuccessOp = op
+		return
+	}
+
+	s.lastFailureAt = now
+	s.lastFailureOp = op
+	s.lastError = err.Error()
+}
+
+// recordRateLimit notes the rate limit headers of a rollbar response
+func (s *apiStats) recordRateLimit(header http.Header) {
+	if s == nil {
+		return
+	}
+
+	limit, err := strconv.Atoi(header.Get("X-Rate-Limit-Limit"))
+	if err != nil {
+		return
+	}
+	remaining, _ := strconv.Atoi(header.Get("X-Rate-Limit-Remaining"))
+	reset, _ := strconv.ParseInt(header.Get("X-Rate-Limit-Reset"), 10, 64)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rateLimit = limit
+	s.rateLimitRemaining = remaining
+	s.rateLimitReset = time.Unix(reset, 0).UTC()
+	s.rateLimitSeenAt = time.Now().UTC()
+}
+
+// toResponseData returns response data for the API call statistics
+func (s *apiStats) toResponseData() map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data := map[string]interface{}{
+		"last_success": nil,
+		"last_failure": nil,
+		"rate_limit":   nil,
+	}
+
+	if !s.lastSuccessAt.IsZero() {
+		data["last_success"] = map[string]interface{}{
+			"time":      s.lastSuccessAt,
+			"operation": s.lastSuccessOp,
+		}
+	}
+
+	if !s.lastFailureAt.IsZero() {
+		data["last_failure"] = map[string]interface{}{
+			"time":      s.lastFailureAt,
+			"operation": s.lastFailureOp,
+			"error":     s.lastError,
+		}
+	}
+
+	if !s.rateLimitSeenAt.IsZero() {
+		data["rate_limit"] = map[string]interface{}{
+			"limit":     s.rateLimit,
+			"remaining": s.rateLimitRemaining,
+			"reset_at":  s.rateLimitReset,
+			"seen_at":   s.rateLimitSeenAt,
+		}
+	}
+
+	return data
+}
@@ -1,5 +1,7 @@
 package plugin
 
+import "strings"
+
 func contains(s []string, e string) bool {
 	for _, a := range s {
 		if a == e {
@@ -8,3 +10,52 @@ func contains(s []string, e string) bool {
 	}
 	return false
 }
+
+// splitScopes splits a comma separated list of scopes, dropping
+// surrounding whitespace and empty elements
+func splitScopes(s string) []string {
+	scopes := []string{}
+	for _, scope := range strings.Split(s, ",") {
+		scope = strings.TrimSpace(scope)
+		if scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// normalizeScopes splits comma joined elements of a scope list and drops
+// surrounding whitespace and empty elements. A list without scopes becomes
+// nil so roles compare equal however their scopes were given.
+func normalizeScopes(scopes []string) []string {
+	normalized := splitScopes(strings.Join(scopes, ","))
+	if len(normalized) == 0 {
+		return nil
+	}
+	return normalized
+}
+
+// sameScopes reports whether both lists hold the same set of scopes
+func sameScopes(a, b []string) bool {
+	for _, scope := range a {
+		if !contains(b, scope) {
+			return false
+		}
+	}
+	for _, scope := range b {
+		if !contains(a, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesMetadata reports whether have holds every key=value pair of want
+func matchesMetadata(have, want map[string]string) bool {
+	for k, v := range want {
+		if have[k] != v {
+			return false
+		}
+	}
+	return true
+}